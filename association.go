@@ -0,0 +1,183 @@
+package caches
+
+import (
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// dependent is a table whose entries depend on the rows of another one through a relation
+type dependent struct {
+	tableName string
+	// foreignKeys are the columns linking the rows, writes not assigning them leave the dependent table alone.
+	// Empty for join tables, any write to which changes the relation.
+	foreignKeys []string
+}
+
+// relations records the dependents of the tables, learnt from the relations of the schemas the plugin sees,
+// so association mode writes (see gorm.Association) evict the tables on both sides of the relation
+type relations struct {
+	mu         sync.RWMutex
+	learnt     map[*schema.Schema]struct{}
+	dependents map[string][]dependent
+}
+
+// learn records the has one, has many and many to many relations of the schema, along with its belongs to
+// relations, the back-references of the has one and has many relations of their owners, so writes to the
+// schema evict its owners even if these were never seen, e.g. in a fresh process
+func (r *relations) learn(s *schema.Schema) {
+	if s == nil {
+		return
+	}
+
+	r.mu.RLock()
+	_, ok := r.learnt[s]
+	r.mu.RUnlock()
+	if ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.learnt == nil {
+		r.learnt = make(map[*schema.Schema]struct{})
+		r.dependents = make(map[string][]dependent)
+	}
+	r.learnt[s] = struct{}{}
+
+	for _, rel := range s.Relationships.Relations {
+		switch {
+		case rel.JoinTable != nil:
+			r.add(rel.JoinTable.Table, dependent{tableName: s.Table})
+			r.add(rel.JoinTable.Table, dependent{tableName: rel.FieldSchema.Table})
+		case rel.Type == schema.HasOne || rel.Type == schema.HasMany:
+			foreignKeys := make([]string, 0, len(rel.References))
+			for _, ref := range rel.References {
+				if ref.OwnPrimaryKey {
+					foreignKeys = append(foreignKeys, ref.ForeignKey.DBName)
+				}
+			}
+			r.add(rel.FieldSchema.Table, dependent{tableName: s.Table, foreignKeys: foreignKeys})
+		case rel.Type == schema.BelongsTo && s.ModelType != nil && s.ModelType.Name() != "":
+			// join tables are anonymous, their sides are given by joinedTables
+			foreignKeys := make([]string, 0, len(rel.References))
+			for _, ref := range rel.References {
+				if !ref.OwnPrimaryKey && ref.PrimaryKey != nil {
+					foreignKeys = append(foreignKeys, ref.ForeignKey.DBName)
+				}
+			}
+			r.add(s.Table, dependent{tableName: rel.FieldSchema.Table, foreignKeys: foreignKeys})
+		}
+	}
+}
+
+func (r *relations) add(tableName string, dep dependent) {
+	for _, existing := range r.dependents[tableName] {
+		if existing.tableName == dep.tableName {
+			return
+		}
+	}
+	r.dependents[tableName] = append(r.dependents[tableName], dep)
+}
+
+// dependentsOf returns the tables depending on the given one
+func (r *relations) dependentsOf(tableName string) []dependent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.dependents[tableName]
+}
+
+// joinedTables returns the tables linked by the join table of a many to many relation, which gorm builds
+// as an anonymous struct belonging to both sides, so they are known even before any owner is seen
+func joinedTables(s *schema.Schema) []string {
+	if s == nil || s.ModelType == nil || s.ModelType.Name() != "" {
+		return nil
+	}
+
+	tables := make([]string, 0, 2)
+	for _, rel := range s.Relationships.Relations {
+		if rel.Type == schema.BelongsTo && rel.FieldSchema != nil && rel.FieldSchema.Table != s.Table {
+			tables = append(tables, rel.FieldSchema.Table)
+		}
+	}
+	return tables
+}
+
+// evictRelated evicts the lists of the tables on the other side of the relations written by the statement:
+// both sides of a join table on any write, the owners of has one and has many relations on deletions
+// and on writes assigning their foreign keys
+func (c *Caches) evictRelated(db *gorm.DB, caller string, deleted bool) {
+	tableName := db.Statement.Table
+	tables := joinedTables(db.Statement.Schema)
+
+	assigned := assignedColumns(db)
+	for _, dep := range c.relations.dependentsOf(tableName) {
+		if len(dep.foreignKeys) > 0 && !deleted && !assignsAny(assigned, dep.foreignKeys) {
+			continue
+		}
+		tables = append(tables, dep.tableName)
+	}
+
+	seen := map[string]struct{}{tableName: {}}
+	for _, dependentTable := range tables {
+		if _, ok := seen[dependentTable]; ok || c.tableIgnoredCache(dependentTable) {
+			continue
+		}
+		seen[dependentTable] = struct{}{}
+		c.evictTable(db, caller, dependentTable, LIST_KEY, IDS_KEY)
+	}
+}
+
+// assignedColumns returns the columns assigned by an update statement, out of its destination
+// as gorm drops the SET clause once the update is done
+func assignedColumns(db *gorm.DB) map[string]struct{} {
+	assigned := make(map[string]struct{})
+	dbName := func(name string) string {
+		if db.Statement.Schema != nil {
+			if field := db.Statement.Schema.LookUpField(name); field != nil {
+				return field.DBName
+			}
+		}
+		return name
+	}
+
+	for _, name := range db.Statement.Selects {
+		assigned[dbName(name)] = struct{}{}
+	}
+
+	switch dest := db.Statement.Dest.(type) {
+	case map[string]interface{}:
+		for name := range dest {
+			assigned[dbName(name)] = struct{}{}
+		}
+	case *map[string]interface{}:
+		for name := range *dest {
+			assigned[dbName(name)] = struct{}{}
+		}
+	default:
+		if db.Statement.Schema == nil {
+			break
+		}
+		rv := reflect.Indirect(reflect.ValueOf(dest))
+		if rv.Kind() != reflect.Struct || rv.Type() != db.Statement.Schema.ModelType {
+			break
+		}
+		for _, field := range db.Statement.Schema.Fields {
+			if _, isZero := field.ValueOf(db.Statement.Context, rv); !isZero && field.DBName != "" {
+				assigned[field.DBName] = struct{}{}
+			}
+		}
+	}
+	return assigned
+}
+
+func assignsAny(assigned map[string]struct{}, columns []string) bool {
+	for _, column := range columns {
+		if _, ok := assigned[column]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package caches
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+// cacherPrefixMock is a MemoryCacher recording the prefixes it deletes
+type cacherPrefixMock struct {
+	*MemoryCacher
+	mu       sync.Mutex
+	prefixes []string
+}
+
+func (c *cacherPrefixMock) DeleteWithPrefix(prefix string) error {
+	c.mu.Lock()
+	c.prefixes = append(c.prefixes, prefix)
+	c.mu.Unlock()
+	return c.MemoryCacher.DeleteWithPrefix(prefix)
+}
+
+func (c *cacherPrefixMock) reset() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefixes := c.prefixes
+	c.prefixes = nil
+	return prefixes
+}
+
+func Test_joinedTables(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err := db.Statement.Parse(&mockAuthor{}); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	if actual := joinedTables(db.Statement.Schema); actual != nil {
+		t.Errorf("joinedTables expected not to return tables of a model, got %v", actual)
+	}
+
+	joinTable := db.Statement.Schema.Relationships.Relations["Tags"].JoinTable
+	actual := joinedTables(joinTable)
+	sort.Strings(actual)
+	if expected := []string{"mock_authors", "mock_tags"}; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("joinedTables expected to return %v but got %v", expected, actual)
+	}
+}
+
+func TestCaches_Association(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	cacher := &cacherPrefixMock{MemoryCacher: NewMemoryCacher(0)}
+	caches := &Caches{
+		Conf: &Config{
+			InstanceId:       "1",
+			Cacher:           cacher,
+			Serializer:       JSONSerializer{},
+			SyncInvalidation: true,
+		},
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	caches.queryCb = func(tx *gorm.DB) {}
+
+	// the relations of the authors are learnt as they are queried
+	var authors []mockAuthor
+	db.Find(&authors)
+
+	listOf := func(tableName string) string {
+		return GenCacheKey("1", tableName, LIST_KEY)
+	}
+	for name, tc := range map[string]struct {
+		column   string
+		run      func(association *gorm.Association) error
+		expected []string
+	}{
+		"append many to many": {
+			column: "Tags",
+			run: func(association *gorm.Association) error {
+				return association.Append(&mockTag{ID: 2})
+			},
+			expected: []string{listOf("mock_authors"), listOf("mock_tags"), listOf("mock_author_tags")},
+		},
+		"delete many to many": {
+			column: "Tags",
+			run: func(association *gorm.Association) error {
+				return association.Delete(&mockTag{ID: 2})
+			},
+			expected: []string{listOf("mock_authors"), listOf("mock_tags"), listOf("mock_author_tags")},
+		},
+		"clear many to many": {
+			column: "Tags",
+			run: func(association *gorm.Association) error {
+				return association.Clear()
+			},
+			expected: []string{listOf("mock_authors"), listOf("mock_tags"), listOf("mock_author_tags")},
+		},
+		"delete has many": {
+			column: "Books",
+			run: func(association *gorm.Association) error {
+				return association.Delete(&mockBook{ID: 5})
+			},
+			expected: []string{listOf("mock_authors"), listOf("mock_books")},
+		},
+		"clear has many": {
+			column: "Books",
+			run: func(association *gorm.Association) error {
+				return association.Clear()
+			},
+			expected: []string{listOf("mock_authors"), listOf("mock_books")},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cacher.reset()
+			if err := tc.run(db.Model(&mockAuthor{ID: 1}).Association(tc.column)); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+
+			// the prefixes are evicted as such or along with their whole table
+			evicted := cacher.reset()
+			for _, prefix := range tc.expected {
+				covered := false
+				for _, evictedPrefix := range evicted {
+					covered = covered || strings.HasPrefix(prefix, evictedPrefix)
+				}
+				if !covered {
+					t.Errorf("expected `%s` to be evicted, got %v", prefix, evicted)
+				}
+			}
+		})
+	}
+
+	t.Run("unrelated writes", func(t *testing.T) {
+		cacher.reset()
+		db.Model(&mockBook{}).Where("id = ?", 5).Update("title", "gorm")
+		for _, prefix := range cacher.reset() {
+			if prefix == listOf("mock_authors") {
+				t.Errorf("expected writes not assigning the foreign key to leave the owner, got `%s`", prefix)
+			}
+		}
+	})
+}
+
+type mockShelf struct {
+	ID    uint
+	Books []mockShelvedBook `gorm:"foreignKey:ShelfID"`
+}
+
+type mockShelvedBook struct {
+	ID      uint
+	ShelfID uint
+	Shelf   *mockShelf
+}
+
+func TestCaches_Association_backReference(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	cacher := &cacherPrefixMock{MemoryCacher: NewMemoryCacher(0)}
+	caches := &Caches{
+		Conf: &Config{
+			InstanceId:       "1",
+			Cacher:           cacher,
+			Serializer:       JSONSerializer{},
+			SyncInvalidation: true,
+		},
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	// no shelf has been queried, the owner is learnt from the back-reference of the written books
+	if err := db.Model(&mockShelf{ID: 1}).Association("Books").Delete(&mockShelvedBook{ID: 5}); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	evicted := cacher.reset()
+	list := GenCacheKey("1", "mock_shelves", LIST_KEY)
+	covered := false
+	for _, prefix := range evicted {
+		covered = covered || strings.HasPrefix(list, prefix)
+	}
+	if !covered {
+		t.Errorf("expected `%s` to be evicted, got %v", list, evicted)
+	}
+}
@@ -0,0 +1,274 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_FAILURE_THRESHOLD = 5
+	DEFAULT_OPEN_TIMEOUT      = 10 * time.Second
+
+	// MAX_SKIPPED_EVICTIONS bounds the evictions recorded while the breaker is open,
+	// past which the whole instance is flushed once it closes
+	MAX_SKIPPED_EVICTIONS = 1000
+)
+
+var ErrCircuitOpen = errors.New("gorm:caches: circuit breaker is open")
+
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through to the Cacher
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every call without reaching the Cacher
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe call through, deciding whether to close or re-open
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures tripping the breaker
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before probing the Cacher again
+	OpenTimeout time.Duration
+	// SlowCallThreshold counts calls taking longer than the given duration as failures (disabled if zero)
+	SlowCallThreshold time.Duration
+
+	// OnStateChange is called on every state transition, e.g. to report metrics
+	OnStateChange func(from, to BreakerState)
+}
+
+// CircuitBreaker is a Cacher skipping the I/O to the wrapped Cacher while it is failing
+type CircuitBreaker struct {
+	Cacher
+
+	conf CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(cacher Cacher, conf CircuitBreakerConfig) *CircuitBreaker {
+	if conf.FailureThreshold <= 0 {
+		conf.FailureThreshold = DEFAULT_FAILURE_THRESHOLD
+	}
+	if conf.OpenTimeout <= 0 {
+		conf.OpenTimeout = DEFAULT_OPEN_TIMEOUT
+	}
+
+	return &CircuitBreaker{
+		Cacher: cacher,
+		conf:   conf,
+	}
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *CircuitBreaker) Get(key string) ([]byte, error) {
+	var res []byte
+	err := b.do(func() (err error) {
+		res, err = b.Cacher.Get(key)
+		return err
+	})
+	return res, err
+}
+
+func (b *CircuitBreaker) Set(key string, val []byte, ttl time.Duration) error {
+	return b.do(func() error {
+		return b.Cacher.Set(key, val, ttl)
+	})
+}
+
+func (b *CircuitBreaker) SetWithTags(key string, val []byte, ttl time.Duration, tags []string) error {
+	return b.do(func() error {
+		return setWithTags(b.Cacher, key, val, ttl, tags)
+	})
+}
+
+// InvalidateTags fails with ErrTagsUnsupported if the wrapped Cacher does not support tags
+func (b *CircuitBreaker) InvalidateTags(tags ...string) error {
+	tagged, ok := b.Cacher.(TaggedCacher)
+	if !ok {
+		return ErrTagsUnsupported
+	}
+	return b.do(func() error {
+		return tagged.InvalidateTags(tags...)
+	})
+}
+
+func (b *CircuitBreaker) GetMulti(keys ...string) ([][]byte, error) {
+	var res [][]byte
+	err := b.do(func() (err error) {
+		res, err = getMulti(b.Cacher, keys)
+		return err
+	})
+	return res, err
+}
+
+func (b *CircuitBreaker) SetMulti(vals map[string][]byte, ttl time.Duration) error {
+	return b.do(func() error {
+		return setMulti(b.Cacher, vals, ttl)
+	})
+}
+
+func (b *CircuitBreaker) DeleteMulti(keys ...string) error {
+	return b.do(func() error {
+		return deleteMulti(b.Cacher, keys)
+	})
+}
+
+func (b *CircuitBreaker) Delete(key string) error {
+	return b.do(func() error {
+		return b.Cacher.Delete(key)
+	})
+}
+
+func (b *CircuitBreaker) DeleteWithPrefix(keyPrefix string) error {
+	return b.do(func() error {
+		return b.Cacher.DeleteWithPrefix(keyPrefix)
+	})
+}
+
+func (b *CircuitBreaker) do(call func() error) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+
+	start := time.Now()
+	err := call()
+	failed := err != nil || (b.conf.SlowCallThreshold > 0 && time.Since(start) > b.conf.SlowCallThreshold)
+	b.record(failed)
+	return err
+}
+
+func (b *CircuitBreaker) allow() (allowed bool) {
+	b.transition(func() {
+		switch b.state {
+		case BreakerOpen:
+			if time.Since(b.openedAt) < b.conf.OpenTimeout {
+				return
+			}
+			b.state = BreakerHalfOpen
+		case BreakerHalfOpen:
+			if b.probing {
+				return
+			}
+		default:
+			allowed = true
+			return
+		}
+
+		b.probing = true
+		allowed = true
+	})
+	return allowed
+}
+
+func (b *CircuitBreaker) record(failed bool) {
+	b.transition(func() {
+		if b.state == BreakerHalfOpen {
+			b.probing = false
+			if failed {
+				b.trip()
+			} else {
+				b.failures = 0
+				b.state = BreakerClosed
+			}
+			return
+		}
+
+		if !failed {
+			b.failures = 0
+			return
+		}
+
+		b.failures++
+		if b.state == BreakerClosed && b.failures >= b.conf.FailureThreshold {
+			b.trip()
+		}
+	})
+}
+
+func (b *CircuitBreaker) trip() {
+	b.failures = 0
+	b.openedAt = time.Now()
+	b.state = BreakerOpen
+}
+
+// transition runs fn holding the lock, and reports the state change once released
+func (b *CircuitBreaker) transition(fn func()) {
+	b.mu.Lock()
+	from := b.state
+	fn()
+	to := b.state
+	b.mu.Unlock()
+
+	if from != to && b.conf.OnStateChange != nil {
+		b.conf.OnStateChange(from, to)
+	}
+}
+
+// skippedEvictions are the invalidations which failed while the CircuitBreaker was open,
+// applied once it closes so the entries written before the outage are not served stale
+type skippedEvictions struct {
+	mu       sync.Mutex
+	events   []InvalidationEvent
+	overflow bool
+}
+
+// skipEviction records the invalidation failed while the breaker is open
+func (c *Caches) skipEviction(event InvalidationEvent) {
+	c.skipped.mu.Lock()
+	defer c.skipped.mu.Unlock()
+
+	if c.skipped.overflow {
+		return
+	}
+	if len(c.skipped.events) >= MAX_SKIPPED_EVICTIONS {
+		c.skipped.events, c.skipped.overflow = nil, true
+		return
+	}
+	c.skipped.events = append(c.skipped.events, event)
+}
+
+// recoverEvictions applies the evictions skipped while the breaker was open, flushing the whole instance
+// if there were too many of them. Those failing again are recorded again.
+func (c *Caches) recoverEvictions() {
+	c.skipped.mu.Lock()
+	events, overflow := c.skipped.events, c.skipped.overflow
+	c.skipped.events, c.skipped.overflow = nil, false
+	c.skipped.mu.Unlock()
+
+	if overflow {
+		events = []InvalidationEvent{{InstanceId: c.Conf.InstanceId, All: true}}
+	}
+	for _, event := range events {
+		for _, err := range c.invalidate(context.Background(), event, false) {
+			if !errors.Is(err, ErrCircuitOpen) {
+				c.logger.Error(context.Background(), "[recoverEvictions - Delete] %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var transitions []string
+	cacher := &cacherFailingMock{err: errors.New("cacher-down")}
+	breaker := NewCircuitBreaker(cacher, CircuitBreakerConfig{
+		FailureThreshold: 3,
+		OpenTimeout:      50 * time.Millisecond,
+		OnStateChange: func(from, to BreakerState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := breaker.Get("key"); err != cacher.err {
+			t.Fatalf("Get expected to return the cacher error, got %v", err)
+		}
+	}
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("expected the breaker to trip after 3 failures, state %s", breaker.State())
+	}
+
+	if _, err := breaker.Get("key"); err != ErrCircuitOpen {
+		t.Errorf("Get expected to return %v while open, got %v", ErrCircuitOpen, err)
+	}
+	if _, err := breaker.GetMulti("key", "other"); err != ErrCircuitOpen {
+		t.Errorf("GetMulti expected to return %v while open, got %v", ErrCircuitOpen, err)
+	}
+	if calls := atomic.LoadInt32(&cacher.calls); calls != 3 {
+		t.Errorf("expected the cacher not to be called while open, called %d times", calls)
+	}
+
+	// the probe fails, re-opening the breaker
+	time.Sleep(60 * time.Millisecond)
+	_ = breaker.Set("key", nil, 0)
+	if breaker.State() != BreakerOpen {
+		t.Errorf("expected a failed probe to re-open the breaker, state %s", breaker.State())
+	}
+
+	// the probe succeeds, closing the breaker
+	time.Sleep(60 * time.Millisecond)
+	cacher.err = nil
+	if err := breaker.Delete("key"); err != nil {
+		t.Errorf("Delete returned an unexpected error %v", err)
+	}
+	if breaker.State() != BreakerClosed {
+		t.Errorf("expected a successful probe to close the breaker, state %s", breaker.State())
+	}
+
+	expected := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("expected transitions %v, got %v", expected, transitions)
+			break
+		}
+	}
+}
+
+func TestCircuitBreaker_SlowCalls(t *testing.T) {
+	breaker := NewCircuitBreaker(&slowCacherMock{delay: 20 * time.Millisecond}, CircuitBreakerConfig{
+		FailureThreshold:  1,
+		SlowCallThreshold: 5 * time.Millisecond,
+	})
+
+	_, _ = breaker.Get("key")
+	if breaker.State() != BreakerOpen {
+		t.Errorf("expected a slow call to trip the breaker, state %s", breaker.State())
+	}
+}
+
+type slowCacherMock struct {
+	cacherMock
+	delay time.Duration
+}
+
+func (c *slowCacherMock) Get(key string) ([]byte, error) {
+	time.Sleep(c.delay)
+	return nil, nil
+}
+
+func TestCaches_CircuitBreaker_recovery(t *testing.T) {
+	cacher := &cacherDeleteMock{err: errors.New("cacher-down")}
+	caches := &Caches{
+		Conf: &Config{
+			InstanceId: "1",
+			Cacher:     cacher,
+			Serializer: JSONSerializer{},
+			CircuitBreaker: &CircuitBreakerConfig{
+				FailureThreshold: 1,
+				OpenTimeout:      20 * time.Millisecond,
+			},
+		},
+	}
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+
+	// the failure trips the breaker, the next eviction is skipped
+	_ = caches.InvalidateTable(context.Background(), "users")
+	if err := caches.InvalidateTable(context.Background(), "orders"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("InvalidateTable expected to return %v, got %v", ErrCircuitOpen, err)
+	}
+
+	cacher.mu.Lock()
+	cacher.err, cacher.prefixes = nil, nil
+	cacher.mu.Unlock()
+	time.Sleep(30 * time.Millisecond)
+
+	// the probe closes the breaker, applying the skipped evictions
+	if err := caches.InvalidateTable(context.Background(), "products"); err != nil {
+		t.Fatalf("InvalidateTable returned an unexpected error %v", err)
+	}
+	if err := caches.Flush(context.Background()); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	for _, tableName := range []string{"users", "orders"} {
+		if !ContainString(GenCachePrefix("1", tableName), cacher.deletedPrefixes()) {
+			t.Errorf("expected the eviction of %s skipped while open to be applied, deleted %v", tableName, cacher.deletedPrefixes())
+		}
+	}
+}
+
+func TestCaches_CircuitBreaker_reinitialize(t *testing.T) {
+	cacher := &cacherMock{}
+	caches := &Caches{
+		Conf: &Config{
+			Cacher:         cacher,
+			Serializer:     JSONSerializer{},
+			CircuitBreaker: &CircuitBreakerConfig{},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+		if err := caches.Initialize(db); err != nil {
+			t.Fatalf("Initialize resulted into an unexpected error, %s", err.Error())
+		}
+	}
+
+	if caches.Conf.Cacher != cacher {
+		t.Error("expected Initialize to leave the configured Cacher in place")
+	}
+	if caches.breaker == nil || caches.breaker.Cacher != cacher {
+		t.Errorf("expected the breaker to wrap the configured Cacher once, got %T", caches.breaker.Cacher)
+	}
+}
@@ -0,0 +1,71 @@
+package caches
+
+import (
+	"context"
+	"sync"
+)
+
+// InvalidationEvent describes the entries of a table to evict, prefixes are relative to the
+// table (see KeyBuilder) so every instance can apply them to its own key space
+type InvalidationEvent struct {
+	// InstanceId is the Config.InstanceId of the instance publishing the event
+	InstanceId string
+	Table      string
+	// Origin identifies the process publishing the event, which ignores it when received, see Config.OriginId
+	Origin string
+	// Tenant scopes the event to the entries of a tenant, see Config.TenantResolver
+	Tenant string
+	// Prefixes are deleted along with every key starting with them: "" is the table prefix, LIST_KEY
+	// and IDS_KEY list prefixes, and any other a detail prefix
+	Prefixes []string
+	// All evicts every entry of the instance
+	All bool
+	// Tags are invalidated on a TaggedCacher, along with every key they are attached to
+	Tags []string
+}
+
+// InvalidationBus broadcasts invalidations between the instances sharing a database
+type InvalidationBus interface {
+	Publish(ctx context.Context, event InvalidationEvent) error
+	// Subscribe registers the handler for every published event, until the returned func is called
+	Subscribe(handler func(InvalidationEvent)) (unsubscribe func(), err error)
+}
+
+// MemoryBus is an in-process InvalidationBus, delivering the events synchronously (suited for tests)
+type MemoryBus struct {
+	mu       sync.RWMutex
+	handlers map[int]func(InvalidationEvent)
+	nextId   int
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, event InvalidationEvent) error {
+	b.mu.RLock()
+	handlers := make([]func(InvalidationEvent), 0, len(b.handlers))
+	for _, handler := range b.handlers {
+		handlers = append(handlers, handler)
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(handler func(InvalidationEvent)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.handlers == nil {
+		b.handlers = make(map[int]func(InvalidationEvent))
+	}
+	id := b.nextId
+	b.nextId++
+	b.handlers[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}, nil
+}
@@ -0,0 +1,84 @@
+package caches
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestMemoryBus(t *testing.T) {
+	var received []InvalidationEvent
+	bus := &MemoryBus{}
+	unsubscribe, err := bus.Subscribe(func(event InvalidationEvent) {
+		received = append(received, event)
+	})
+	if err != nil {
+		t.Fatalf("Subscribe returned an unexpected error %v", err)
+	}
+
+	_ = bus.Publish(context.Background(), InvalidationEvent{Table: "users"})
+	unsubscribe()
+	_ = bus.Publish(context.Background(), InvalidationEvent{Table: "roles"})
+
+	if len(received) != 1 || received[0].Table != "users" {
+		t.Errorf("expected to receive the events published until unsubscribed, got %+v", received)
+	}
+}
+
+func TestCaches_InvalidationBus(t *testing.T) {
+	bus := &MemoryBus{}
+	// the processes share their key namespace, each with its own local cacher
+	newInstance := func() (*Caches, *MemoryCacher, *gorm.DB) {
+		cacher := NewMemoryCacher(0)
+		caches := &Caches{Conf: &Config{
+			InstanceId:       "A",
+			Cacher:           cacher,
+			Serializer:       JSONSerializer{},
+			SyncInvalidation: true,
+			InvalidationBus:  bus,
+		}}
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		return caches, cacher, db
+	}
+
+	cachesA, cacherA, dbA := newInstance()
+	cachesB, cacherB, _ := newInstance()
+	defer cachesA.Close()
+	defer cachesB.Close()
+
+	_ = cacherA.Set(GenCacheKey("A", "users", LIST_KEY+"-query"), []byte("a"), time.Minute)
+	_ = cacherB.Set(GenCacheKey("A", "users", LIST_KEY+"-query"), []byte("b"), time.Minute)
+	_ = cacherB.Set(GenCacheKey("A", "roles", LIST_KEY+"-query"), []byte("b"), time.Minute)
+
+	dbA.Statement.Table = "users"
+	cachesA.AfterUpdate(dbA)
+	if err := cachesB.Flush(context.Background()); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	if val, _ := cacherA.Get(GenCacheKey("A", "users", LIST_KEY+"-query")); val != nil {
+		t.Error("expected the updating instance to evict its entries")
+	}
+	if val, _ := cacherB.Get(GenCacheKey("A", "users", LIST_KEY+"-query")); val != nil {
+		t.Error("expected the other instance to evict its entries of the updated table")
+	}
+	if val, _ := cacherB.Get(GenCacheKey("A", "roles", LIST_KEY+"-query")); val == nil {
+		t.Error("expected the other instance to keep its entries of other tables")
+	}
+
+	// processes ignore their own events
+	_ = cacherA.Set(GenCacheKey("A", "roles", LIST_KEY+"-query"), []byte("a"), time.Minute)
+	_ = bus.Publish(context.Background(), InvalidationEvent{InstanceId: "A", Origin: cachesA.origin(), Table: "roles", Prefixes: []string{""}})
+	if err := cachesA.Flush(context.Background()); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	if val, _ := cacherA.Get(GenCacheKey("A", "roles", LIST_KEY+"-query")); val == nil {
+		t.Error("expected the publishing process to ignore its own event")
+	}
+}
@@ -10,3 +10,67 @@ type Cacher interface {
 	Delete(key string) error
 	DeleteWithPrefix(keyPrefix string) error
 }
+
+// TaggedCacher is an optional extension of the Cacher, grouping keys under tags which can be invalidated at once
+type TaggedCacher interface {
+	Cacher
+
+	SetWithTags(key string, val []byte, ttl time.Duration, tags []string) error
+	InvalidateTags(tags ...string) error
+}
+
+// BatchCacher is an optional extension of the Cacher, reading and writing several keys in a single round trip
+type BatchCacher interface {
+	Cacher
+
+	// GetMulti returns the values of the keys in the same order, nil for the missing ones
+	GetMulti(keys ...string) ([][]byte, error)
+	// SetMulti stores every value under its key with the same TTL
+	SetMulti(vals map[string][]byte, ttl time.Duration) error
+	DeleteMulti(keys ...string) error
+}
+
+// getMulti reads the keys at once if the cacher supports it, one by one otherwise
+func getMulti(cacher Cacher, keys []string) ([][]byte, error) {
+	if batch, ok := cacher.(BatchCacher); ok {
+		return batch.GetMulti(keys...)
+	}
+
+	vals := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		val, err := cacher.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
+
+// setMulti stores the values at once if the cacher supports it, one by one otherwise
+func setMulti(cacher Cacher, vals map[string][]byte, ttl time.Duration) error {
+	if batch, ok := cacher.(BatchCacher); ok {
+		return batch.SetMulti(vals, ttl)
+	}
+
+	for key, val := range vals {
+		if err := cacher.Set(key, val, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteMulti deletes the keys at once if the cacher supports it, one by one otherwise
+func deleteMulti(cacher Cacher, keys []string) error {
+	if batch, ok := cacher.(BatchCacher); ok {
+		return batch.DeleteMulti(keys...)
+	}
+
+	for _, key := range keys {
+		if err := cacher.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -79,3 +80,65 @@ func (c *cacherStoreErrorMock) Delete(key string) error {
 func (c *cacherStoreErrorMock) DeleteWithPrefix(keyPrefix string) error {
 	return nil
 }
+
+type cacherDeleteMock struct {
+	cacherMock
+
+	mu       sync.Mutex
+	prefixes []string
+	err      error
+}
+
+func (c *cacherDeleteMock) DeleteWithPrefix(keyPrefix string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prefixes = append(c.prefixes, keyPrefix)
+	return c.err
+}
+
+func (c *cacherDeleteMock) deletedPrefixes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.prefixes...)
+}
+
+type cacherFailingMock struct {
+	calls int32
+	err   error
+}
+
+func (c *cacherFailingMock) Get(key string) ([]byte, error) {
+	atomic.AddInt32(&c.calls, 1)
+	return nil, c.err
+}
+
+func (c *cacherFailingMock) Set(key string, val []byte, ttl time.Duration) error {
+	atomic.AddInt32(&c.calls, 1)
+	return c.err
+}
+
+func (c *cacherFailingMock) Delete(key string) error {
+	atomic.AddInt32(&c.calls, 1)
+	return c.err
+}
+
+func (c *cacherFailingMock) DeleteWithPrefix(keyPrefix string) error {
+	atomic.AddInt32(&c.calls, 1)
+	return c.err
+}
+
+// cacherCountingMock is a MemoryCacher counting its reads
+type cacherCountingMock struct {
+	*MemoryCacher
+	gets, multiGets int32
+}
+
+func (c *cacherCountingMock) Get(key string) ([]byte, error) {
+	atomic.AddInt32(&c.gets, 1)
+	return c.MemoryCacher.Get(key)
+}
+
+func (c *cacherCountingMock) GetMulti(keys ...string) ([][]byte, error) {
+	atomic.AddInt32(&c.multiGets, 1)
+	return c.MemoryCacher.GetMulti(keys...)
+}
@@ -1,18 +1,70 @@
 package caches
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
 	"sync"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
 )
 
 type Caches struct {
 	Conf *Config
 
-	queue   *sync.Map
-	queryCb func(*gorm.DB)
+	queue      *sync.Map
+	refreshing sync.Map
+	queryCb    func(*gorm.DB)
+	rowCb      func(*gorm.DB)
+	relations  relations
+
+	// breaker wraps Config.Cacher, see Config.CircuitBreaker
+	breaker *CircuitBreaker
+	skipped skippedEvictions
+
+	originId   string
+	originOnce sync.Once
+
+	pool     *workerPool
+	poolOnce sync.Once
+
+	db          *gorm.DB
+	logger      logger.Interface
+	unsubscribe func()
+}
+
+const SYNC_INVALIDATION_KEY = "gorm:caches:sync_invalidation"
+
+// ErrorPolicy decides how Cacher errors of synchronous operations are surfaced
+type ErrorPolicy int
+
+const (
+	// ErrorPolicyLog reports the error through the gorm logger
+	ErrorPolicyLog ErrorPolicy = iota
+	// ErrorPolicyAddError adds the error to the statement, failing the write
+	ErrorPolicyAddError
+)
+
+// FailurePolicy decides how queries behave when the Cacher is unavailable
+type FailurePolicy int
+
+const (
+	// FailOpen treats Cacher errors as cache misses, running the query against the database
+	FailOpen FailurePolicy = iota
+	// FailClosed fails the query with the Cacher error
+	FailClosed
+)
+
+// WithSyncInvalidation overrides Config.SyncInvalidation for the queries run with the returned context
+func WithSyncInvalidation(ctx context.Context, sync bool) context.Context {
+	return context.WithValue(ctx, SYNC_INVALIDATION_KEY, sync)
 }
 
 type Config struct {
@@ -22,6 +74,77 @@ type Config struct {
 	Serializer Serializer
 	CacheTTL   time.Duration
 
+	// CacheTTLJitter randomly shortens the TTL of every stored entry by up to the given fraction (0 to 1),
+	// so entries created at the same time do not expire together
+	CacheTTLJitter float64
+
+	// EarlyExpirationBeta enables probabilistic early recomputation (XFetch) when greater than zero,
+	// higher values recompute earlier, 1 is a sensible default
+	EarlyExpirationBeta float64
+
+	// NegativeCacheTTL enables caching of `record not found` errors and empty results when greater than zero,
+	// such entries live for the given (usually short) duration and replay gorm.ErrRecordNotFound on hit
+	NegativeCacheTTL time.Duration
+
+	// CacheSoftTTL marks cached entries as stale once elapsed, stale entries are still served
+	// while a single background refresh re-runs the query (disabled if zero or not lower than CacheTTL)
+	CacheSoftTTL time.Duration
+
+	// Workers is the number of goroutines running asynchronous cache writes and evictions
+	Workers int
+	// QueueSize bounds the asynchronous work waiting for a worker
+	QueueSize int
+	// QueuePolicy decides what happens to asynchronous work while the queue is full, evictions are never dropped
+	QueuePolicy QueuePolicy
+
+	// SyncInvalidation evicts entries inside the write callbacks instead of on the worker pool,
+	// so a read following a write never gets the old cached version (can be overridden per query, see WithSyncInvalidation)
+	SyncInvalidation bool
+	// InvalidationErrorPolicy decides how errors of synchronous invalidations are surfaced
+	InvalidationErrorPolicy ErrorPolicy
+
+	// StaleWriteProtection records a tombstone on every invalidation, cache writes of queries started
+	// before the latest tombstone of their table are discarded (or deleted once stored, if it lands meanwhile)
+	// instead of re-populating stale data
+	StaleWriteProtection bool
+
+	// CircuitBreaker wraps the Cacher into a CircuitBreaker, skipping the cache I/O while it is failing.
+	// The evictions skipped meanwhile are applied once it closes again.
+	CircuitBreaker *CircuitBreakerConfig
+	// CacherFailurePolicy decides whether queries fall back to the database (FailOpen)
+	// or fail (FailClosed) when the Cacher cannot be read
+	CacherFailurePolicy FailurePolicy
+
+	// InvalidationBus broadcasts the invalidations to the other instances, and applies theirs to the Cacher.
+	// Events are deduplicated by OriginId, instances sharing a Cacher share their InstanceId.
+	InvalidationBus InvalidationBus
+	// OriginId identifies the process on the InvalidationBus, so it ignores its own events (random if empty)
+	OriginId string
+
+	// EntityCache stores the result of single-row lookups by primary key (First, Take or Last filtered by
+	// primary key only) under one entry per row, shared by every such lookup however it is written
+	EntityCache bool
+
+	// IDListCache caches list queries loading whole rows of a model with a single primary field as the ordered
+	// list of their primary keys, the rows being stored in the entries of EntityCache and fetched by primary key
+	// when missing. Updates by primary key only evict the entries of the updated rows, so such lists keep their
+	// rows until a row of the table is created, deleted or updated without primary keys (evicting the whole
+	// table), even if the rows no longer match the query.
+	IDListCache bool
+
+	// PreloadCache caches queries with preloads as a whole, the rows along with their preloaded associations,
+	// in one entry evicted by the writes to any table involved (its own, the associations' and their join tables).
+	// Otherwise the rows are cached without their associations, and every preload runs its own cached query.
+	// Preloads with scopes are never cached as a whole.
+	PreloadCache bool
+
+	// KeyBuilder builds the keys of the Cacher (DefaultKeyBuilder if nil)
+	KeyBuilder KeyBuilder
+
+	// TenantResolver returns the tenant of the context, folded into every key and prefix so invalidations of
+	// a tenant leave the entries of the others. Invalidations without a tenant evict the table for every tenant.
+	TenantResolver func(ctx context.Context) string
+
 	// Tables only cache data within given data tables (cache all if empty)
 	Tables []string
 }
@@ -42,17 +165,55 @@ func (c *Caches) Initialize(db *gorm.DB) error {
 		c.queue = &sync.Map{}
 	}
 
+	c.db = db
+	c.logger = db.Logger
+	c.workerPool()
+
+	c.breaker = nil
+	if c.Conf.CircuitBreaker != nil && c.Conf.Cacher != nil {
+		conf := *c.Conf.CircuitBreaker
+		onStateChange := conf.OnStateChange
+		conf.OnStateChange = func(from, to BreakerState) {
+			db.Logger.Warn(context.Background(), "[CircuitBreaker] cacher state changed from %s to %s", from, to)
+			if to == BreakerClosed {
+				c.asyncEviction(c.recoverEvictions)
+			}
+			if onStateChange != nil {
+				onStateChange(from, to)
+			}
+		}
+		c.breaker = NewCircuitBreaker(c.Conf.Cacher, conf)
+	}
+
+	if c.Conf.InvalidationBus != nil {
+		unsubscribe, err := c.Conf.InvalidationBus.Subscribe(c.onInvalidation)
+		if err != nil {
+			return err
+		}
+		c.unsubscribe = unsubscribe
+	}
+
 	c.queryCb = db.Callback().Query().Get("gorm:query")
 
 	if err := db.Callback().Query().Replace("gorm:query", c.Query); err != nil {
 		return err
 	}
 
+	c.rowCb = db.Callback().Row().Get("gorm:row")
+
+	if err := db.Callback().Row().Replace("gorm:row", c.Row); err != nil {
+		return err
+	}
+
+	if err := db.Callback().Query().After("gorm:preload").Register("gorm:cache:after_preload", c.AfterPreload); err != nil {
+		return err
+	}
+
 	if err := db.Callback().Create().After("*").Register("gorm:cache:after_create", c.AfterCreate); err != nil {
 		return err
 	}
 
-	if err := db.Callback().Delete().After("*").Register("gorm:cache:after_delete", c.AfterUpdate); err != nil {
+	if err := db.Callback().Delete().After("*").Register("gorm:cache:after_delete", c.AfterDelete); err != nil {
 		return err
 	}
 
@@ -63,7 +224,63 @@ func (c *Caches) Initialize(db *gorm.DB) error {
 	return nil
 }
 
+// Flush waits until all pending asynchronous cache writes and evictions are done, or the context is done
+func (c *Caches) Flush(ctx context.Context) error {
+	return c.workerPool().Flush(ctx)
+}
+
+// Close drains the pending asynchronous work and stops the workers,
+// work submitted afterwards runs synchronously
+func (c *Caches) Close() error {
+	if c.unsubscribe != nil {
+		c.unsubscribe()
+	}
+	return c.workerPool().Close(context.Background())
+}
+
+// Stats returns a snapshot of the asynchronous work queue
+func (c *Caches) Stats() QueueStats {
+	return c.workerPool().Stats()
+}
+
+func (c *Caches) workerPool() *workerPool {
+	c.poolOnce.Do(func() {
+		c.pool = newWorkerPool(c.Conf.Workers, c.Conf.QueueSize, c.Conf.QueuePolicy)
+	})
+	return c.pool
+}
+
+// cacher returns the Cacher, wrapped into the CircuitBreaker if any
+func (c *Caches) cacher() Cacher {
+	if c.breaker != nil {
+		return c.breaker
+	}
+	return c.Conf.Cacher
+}
+
+// origin returns Config.OriginId, or a random identifier of the process if empty
+func (c *Caches) origin() string {
+	c.originOnce.Do(func() {
+		c.originId = c.Conf.OriginId
+		if c.originId == "" {
+			c.originId = randomId()
+		}
+	})
+	return c.originId
+}
+
+// async runs the job on the worker pool, it reports false if the job got dropped
+func (c *Caches) async(job func()) bool {
+	return c.workerPool().Submit(job)
+}
+
+// asyncEviction runs the eviction on the worker pool, or inline rather than dropping it (see QueuePolicyDrop)
+func (c *Caches) asyncEviction(job func()) {
+	c.workerPool().SubmitCritical(job)
+}
+
 func (c *Caches) Query(db *gorm.DB) {
+	c.relations.learn(db.Statement.Schema)
 	identifier := c.buildIdentifier(db)
 	if c.ignoredCache(db) {
 		c.ease(db, identifier)
@@ -73,12 +290,17 @@ func (c *Caches) Query(db *gorm.DB) {
 	if c.checkCache(db, identifier) {
 		return
 	}
+	start := c.startStatement(db)
 	c.ease(db, identifier)
-	if db.Error != nil {
+	if db.Error != nil && !c.negativeResult(db) {
 		return
 	}
 
-	go c.storeInCache(db, identifier)
+	if _, ok := c.preloadGraph(db); ok {
+		db.InstanceSet(PRELOAD_GRAPH_KEY, pendingGraph{identifier: identifier, start: start})
+		return
+	}
+	c.storeInCache(db, identifier, start)
 }
 
 func (c *Caches) AfterUpdate(db *gorm.DB) {
@@ -86,24 +308,25 @@ func (c *Caches) AfterUpdate(db *gorm.DB) {
 		return
 	}
 
-	primaryKey := getPrimaryKeyFromWhereClause(db)
-	if primaryKey != LIST_KEY {
-		// evict cache by detail
-		go func() {
-			prefixKey := GenCacheKey(c.Conf.InstanceId, db.Statement.Table, primaryKey)
-			if err := c.Conf.Cacher.DeleteWithPrefix(prefixKey); err != nil {
-				db.Logger.Error(db.Statement.Context, "[AfterUpdate - Delete with key %s] %s", prefixKey, err)
-			}
-		}()
+	// evict cache by list, and by detail
+	keys := rowKeys(getPrimaryKeysFromWhereClause(db), LIST_KEY)
+
+	c.relations.learn(db.Statement.Schema)
+	c.evict(db, "AfterUpdate - Delete with prefix", keys...)
+	c.evictRelated(db, "AfterUpdate - Delete related with prefix", false)
+}
+
+func (c *Caches) AfterDelete(db *gorm.DB) {
+	if db.Error != nil || c.ignoredCache(db) {
+		return
 	}
 
-	// evict cache by list
-	go func() {
-		prefixKey := GenCacheKey(c.Conf.InstanceId, db.Statement.Table, LIST_KEY)
-		if err := c.Conf.Cacher.DeleteWithPrefix(prefixKey); err != nil {
-			db.Logger.Error(db.Statement.Context, "[AfterUpdate - Delete with prefix %s] %s", prefixKey, err)
-		}
-	}()
+	// evict cache by list, ID lists included as they hold the deleted rows, and by detail
+	keys := rowKeys(getPrimaryKeysFromWhereClause(db), LIST_KEY, IDS_KEY)
+
+	c.relations.learn(db.Statement.Schema)
+	c.evict(db, "AfterDelete - Delete with prefix", keys...)
+	c.evictRelated(db, "AfterDelete - Delete related with prefix", true)
 }
 
 func (c *Caches) AfterCreate(db *gorm.DB) {
@@ -112,12 +335,209 @@ func (c *Caches) AfterCreate(db *gorm.DB) {
 	}
 
 	// evict cache by list
-	go func() {
-		prefixKey := GenCacheKey(c.Conf.InstanceId, db.Statement.Table, LIST_KEY)
-		if err := c.Conf.Cacher.DeleteWithPrefix(prefixKey); err != nil {
-			db.Logger.Error(db.Statement.Context, "[AfterUpdate - Delete with prefix %s] %s", prefixKey, err)
+	keys := []string{LIST_KEY, IDS_KEY}
+
+	// evict cache by detail, as queries by primary key may not have found the created rows,
+	// the whole table past MAX_DETAIL_KEYS rows
+	if primaryKeys := getPrimaryKeysFromDest(db); len(primaryKeys) > MAX_DETAIL_KEYS {
+		keys = []string{""}
+	} else {
+		keys = append(keys, primaryKeys...)
+	}
+
+	c.relations.learn(db.Statement.Schema)
+	c.evict(db, "AfterCreate - Delete with prefix", keys...)
+	c.evictRelated(db, "AfterCreate - Delete related with prefix", false)
+}
+
+// rowKeys returns the keys evicted by a write of the rows with the given primary keys, along with the given groups.
+// Writes of rows out of their primary keys may concern any entry of the table, as may writes of more than
+// MAX_DETAIL_KEYS rows, each of their keys costing an eviction: the whole table is evicted instead.
+func rowKeys(primaryKeys []string, groups ...string) []string {
+	if len(primaryKeys) == 0 || len(primaryKeys) > MAX_DETAIL_KEYS {
+		return []string{""}
+	}
+	return append(groups, primaryKeys...)
+}
+
+// evict deletes the entries of the table under the given keys (see InvalidationEvent.Prefixes) and publishes the invalidation,
+// inside the callback when invalidation is synchronous, on the worker pool otherwise
+func (c *Caches) evict(db *gorm.DB, caller string, keys ...string) {
+	c.evictTable(db, caller, db.Statement.Table, keys...)
+}
+
+// evictTable deletes the entries of the given table written to by the statement, as evict does
+func (c *Caches) evictTable(db *gorm.DB, caller string, tableName string, keys ...string) {
+	event := InvalidationEvent{
+		InstanceId: c.Conf.InstanceId,
+		Origin:     c.origin(),
+		Table:      tableName,
+		Tenant:     c.tenant(db.Statement.Context),
+		Prefixes:   keys,
+	}
+
+	if !c.syncInvalidation(db.Statement.Context) {
+		logger, ctx := db.Logger, db.Statement.Context
+		c.asyncEviction(func() {
+			for _, err := range c.invalidate(ctx, event, true) {
+				if !errors.Is(err, ErrCircuitOpen) {
+					logger.Error(ctx, "[%s] %s", caller, err)
+				}
+			}
+		})
+		return
+	}
+
+	for _, err := range c.invalidate(db.Statement.Context, event, true) {
+		if c.Conf.InvalidationErrorPolicy == ErrorPolicyAddError {
+			_ = db.AddError(err)
+			continue
 		}
-	}()
+		if !errors.Is(err, ErrCircuitOpen) {
+			db.Logger.Error(db.Statement.Context, "[%s] %s", caller, err)
+		}
+	}
+}
+
+// invalidate deletes the entries targeted by the event from the Cacher, then publishes it on the
+// InvalidationBus if asked to, once the entries are gone
+func (c *Caches) invalidate(ctx context.Context, event InvalidationEvent, publish bool) []error {
+	if c.cacher() == nil {
+		return nil
+	}
+
+	var errs []error
+	if event.All {
+		// the tombstone goes first, so stores racing with the deletion are discarded
+		if c.Conf.StaleWriteProtection {
+			if err := c.setTombstone(""); err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		prefixKey := c.keys().InstancePrefix(c.Conf.InstanceId)
+		if err := c.cacher().DeleteWithPrefix(prefixKey); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefixKey, err))
+		}
+	}
+
+	// invalidations outside of any tenant may concern the entries of every tenant
+	prefixes := event.Prefixes
+	if c.Conf.TenantResolver != nil && event.Tenant == "" && len(event.Prefixes) > 0 {
+		prefixes = []string{""}
+	}
+	tableName := keyTable(event.Table, event.Tenant)
+
+	if c.Conf.StaleWriteProtection && event.Table != "" {
+		// the tombstone goes first, so stores racing with the deletion are discarded
+		if err := c.setTombstone(tableName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, prefix := range prefixes {
+		prefixKey := c.prefixKey(tableName, prefix)
+		if err := c.cacher().DeleteWithPrefix(prefixKey); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", prefixKey, err))
+		}
+	}
+
+	if len(event.Tags) > 0 {
+		if err := c.invalidateTags(event.Tags, event.Tenant); err != nil {
+			errs = append(errs, fmt.Errorf("tags %v: %w", event.Tags, err))
+		}
+	}
+
+	// evictions failing along with the Cacher are applied again once it recovers
+	if len(errs) > 0 && c.breaker != nil && c.breaker.State() != BreakerClosed {
+		c.skipEviction(event)
+	}
+
+	if publish && c.Conf.InvalidationBus != nil {
+		if err := c.Conf.InvalidationBus.Publish(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("publish: %w", err))
+		}
+	}
+	return errs
+}
+
+// onInvalidation applies the invalidations published by the other instances
+func (c *Caches) onInvalidation(event InvalidationEvent) {
+	if event.Origin == c.origin() || c.cacher() == nil || c.tableIgnoredCache(event.Table) {
+		return
+	}
+
+	c.asyncEviction(func() {
+		for _, err := range c.invalidate(context.Background(), event, false) {
+			if !errors.Is(err, ErrCircuitOpen) {
+				c.logger.Error(context.Background(), "[onInvalidation - Delete] %s", err)
+			}
+		}
+	})
+}
+
+// setTombstone records an invalidation of the table, or of the whole instance if tableName is empty,
+// under a token of its own rather than its time as the clocks of the instances may differ
+func (c *Caches) setTombstone(tableName string) error {
+	return c.cacher().Set(c.tombstoneKey(tableName), []byte(randomId()), TOMBSTONE_TTL)
+}
+
+// queryStart is taken as a query starts: its time measures the query (see Query.Delta), and the tombstones
+// of the tables it reads from tell whether they got invalidated since (see Config.StaleWriteProtection)
+type queryStart struct {
+	time          time.Time
+	tombstoneKeys []string
+	tombstones    [][]byte
+	err           error
+}
+
+// startQuery marks the start of a query of the tenant reading from the given tables
+func (c *Caches) startQuery(tenant string, tables ...string) *queryStart {
+	start := &queryStart{time: time.Now()}
+	if !c.Conf.StaleWriteProtection || c.cacher() == nil {
+		return start
+	}
+
+	// the entries of the tenant are evicted by invalidations of the tenant, of the whole table
+	// and of the whole instance
+	start.tombstoneKeys = append(start.tombstoneKeys, c.tombstoneKey(""))
+	for _, tableName := range tables {
+		start.tombstoneKeys = append(start.tombstoneKeys, c.tombstoneKey(tableName))
+		if tenant != "" {
+			start.tombstoneKeys = append(start.tombstoneKeys, c.tombstoneKey(keyTable(tableName, tenant)))
+		}
+	}
+	start.tombstones, start.err = getMulti(c.cacher(), start.tombstoneKeys)
+	return start
+}
+
+// startStatement marks the start of the query of the statement, reading from its table and from the
+// tables of its preloads when cached as a whole
+func (c *Caches) startStatement(db *gorm.DB) *queryStart {
+	tables, _ := c.preloadGraph(db)
+	return c.startQuery(c.tenant(db.Statement.Context), append([]string{getTableName(db)}, tables...)...)
+}
+
+// invalidatedSince reports whether any table the query reads from got invalidated since it started,
+// its tombstones having changed. Tombstones which cannot be read count as changed.
+func (c *Caches) invalidatedSince(start *queryStart) bool {
+	if len(start.tombstoneKeys) == 0 {
+		return false
+	}
+	if start.err != nil {
+		return true
+	}
+
+	res, err := getMulti(c.cacher(), start.tombstoneKeys)
+	if err != nil {
+		return true
+	}
+	for i, val := range res {
+		if !bytes.Equal(val, start.tombstones[i]) {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *Caches) ease(db *gorm.DB, identifier string) {
@@ -147,7 +567,7 @@ func (c *Caches) ease(db *gorm.DB, identifier string) {
 }
 
 func (c *Caches) checkCache(db *gorm.DB, identifier string) bool {
-	if c.Conf.Cacher == nil {
+	if c.cacher() == nil {
 		return false
 	}
 
@@ -155,7 +575,11 @@ func (c *Caches) checkCache(db *gorm.DB, identifier string) bool {
 		query Query
 	)
 
-	res, err := c.Conf.Cacher.Get(identifier)
+	res, err := c.cacher().Get(identifier)
+	if err != nil && c.Conf.CacherFailurePolicy == FailClosed {
+		_ = db.AddError(err)
+		return true
+	}
 	if err != nil || res == nil {
 		return false
 	}
@@ -164,39 +588,361 @@ func (c *Caches) checkCache(db *gorm.DB, identifier string) bool {
 		return false
 	}
 
-	// binding Statement.Dest
-	serializedDest, err := c.Conf.Serializer.Serialize(query.Dest)
-	if err != nil {
+	if c.expiresEarly(query) {
 		return false
 	}
 
-	if err := c.Conf.Serializer.Deserialize(serializedDest, &db.Statement.Dest); err != nil {
+	// the entry is invalidated as soon as any of its rows is
+	if markerKeys := c.buildMarkerKeys(db); len(markerKeys) > 0 {
+		markers, err := getMulti(c.cacher(), markerKeys)
+		if err != nil && c.Conf.CacherFailurePolicy == FailClosed {
+			_ = db.AddError(err)
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		for _, marker := range markers {
+			if marker == nil {
+				return false
+			}
+		}
+	}
+
+	if query.NotFound {
+		db.Statement.RowsAffected = 0
+		_ = db.AddError(gorm.ErrRecordNotFound)
+		return true
+	}
+
+	if query.IDList {
+		if !c.hydrate(db, query) {
+			return false
+		}
+	} else if err := c.bindDest(db, query.Dest); err != nil {
 		return false
 	}
 
+	// preloaded graphs hold their associations already
+	if _, ok := c.preloadGraph(db); ok {
+		db.Statement.Preloads = nil
+	}
+
 	// binding Statement.RowsAffected
 	db.Statement.RowsAffected = query.RowsAffected
 
+	if !query.StaleAt.IsZero() && time.Now().After(query.StaleAt) {
+		c.revalidate(db, identifier)
+	}
+
 	return true
 }
 
-func (c *Caches) storeInCache(db *gorm.DB, identifier string) {
-	if c.Conf.Cacher == nil {
+// bindDest binds the cached result onto Statement.Dest, restoring the TypedValue of dynamically typed
+// destinations such as maps (see typedDest)
+func (c *Caches) bindDest(db *gorm.DB, dest interface{}) error {
+	serializedDest, err := c.Conf.Serializer.Serialize(dest)
+	if err != nil {
+		return err
+	}
+
+	destType := reflect.TypeOf(db.Statement.Dest)
+	if destType == nil || !hasDynamicValues(destType) {
+		return c.Conf.Serializer.Deserialize(serializedDest, &db.Statement.Dest)
+	}
+
+	// pointers are bound to their element, maps get the cached entries
+	boundType := destType
+	if destType.Kind() == reflect.Ptr {
+		boundType = destType.Elem()
+	}
+	typed := reflect.New(typedType(boundType))
+	if err := c.Conf.Serializer.Deserialize(serializedDest, typed.Interface()); err != nil {
+		return err
+	}
+	restored, err := fromTyped(typed.Elem(), boundType)
+	if err != nil {
+		return err
+	}
+
+	rv := reflect.ValueOf(db.Statement.Dest)
+	switch destType.Kind() {
+	case reflect.Ptr:
+		rv.Elem().Set(restored)
+	case reflect.Map:
+		iter := restored.MapRange()
+		for iter.Next() {
+			rv.SetMapIndex(iter.Key(), iter.Value())
+		}
+	default:
+		return fmt.Errorf("%w: %T", schema.ErrUnsupportedDataType, db.Statement.Dest)
+	}
+	return nil
+}
+
+// revalidate refreshes a stale entry in the background, re-running the query on a cloned statement.
+// Only one refresh per identifier is in flight at any time.
+func (c *Caches) revalidate(db *gorm.DB, identifier string) {
+	if _, loaded := c.refreshing.LoadOrStore(identifier, struct{}{}); loaded {
 		return
 	}
 
-	cachedData, err := c.Conf.Serializer.Serialize(Query{
-		Dest:         db.Statement.Dest,
-		RowsAffected: db.Statement.RowsAffected,
+	// the refresh outlives the request, but keeps the values of its context such as the tenant and tags
+	tx := db.Session(&gorm.Session{Context: detachedContext{parent: db.Statement.Context}})
+	tx.Statement.Dest = reflect.New(reflect.Indirect(reflect.ValueOf(db.Statement.Dest)).Type()).Interface()
+	tx.Statement.ReflectValue = reflect.ValueOf(tx.Statement.Dest).Elem()
+
+	scheduled := c.async(func() {
+		defer c.refreshing.Delete(identifier)
+
+		start := c.startStatement(tx)
+		c.queryCb(tx)
+		if tx.Error != nil && !c.negativeResult(tx) {
+			tx.Logger.Error(tx.Statement.Context, "[revalidate - Query] %s", tx.Error)
+			return
+		}
+		entries, err := c.snapshot(tx, identifier, start)
+		if err != nil {
+			tx.Logger.Error(tx.Statement.Context, "[revalidate - Serialize] %s", err)
+			return
+		}
+		c.setCache(tx.Logger, tx.Statement.Context, entries...)
 	})
+	if !scheduled {
+		c.refreshing.Delete(identifier)
+	}
+}
+
+// detachedContext holds the values of its parent, without its cancellation nor its deadline
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	if c.parent == nil {
+		return nil
+	}
+	return c.parent.Value(key)
+}
+
+// negativeResult reports whether the query result has to be cached as a negative entry
+func (c *Caches) negativeResult(db *gorm.DB) bool {
+	if c.Conf.NegativeCacheTTL <= 0 {
+		return false
+	}
+	if db.Error != nil {
+		return errors.Is(db.Error, gorm.ErrRecordNotFound)
+	}
+	return db.Statement.RowsAffected == 0
+}
+
+func (c *Caches) staleWhileRevalidate() bool {
+	return c.Conf.CacheSoftTTL > 0 && (c.Conf.CacheTTL <= 0 || c.Conf.CacheSoftTTL < c.Conf.CacheTTL)
+}
+
+// expiresEarly decides whether a cache hit should be recomputed ahead of its expiry,
+// following the XFetch algorithm: now - delta * beta * ln(rand) >= expiry.
+func (c *Caches) expiresEarly(query Query) bool {
+	if c.Conf.EarlyExpirationBeta <= 0 || query.ExpiresAt.IsZero() || query.Delta <= 0 {
+		return false
+	}
+
+	gap := time.Duration(float64(query.Delta) * c.Conf.EarlyExpirationBeta * -math.Log(1-rand.Float64()))
+	return !time.Now().Add(gap).Before(query.ExpiresAt)
+}
+
+// cacheTTL returns Config.CacheTTL, shortened by a random share of Config.CacheTTLJitter
+func (c *Caches) cacheTTL() time.Duration {
+	ttl := c.Conf.CacheTTL
+	if ttl > 0 && c.Conf.CacheTTLJitter > 0 {
+		ttl -= time.Duration(float64(ttl) * math.Min(c.Conf.CacheTTLJitter, 1) * rand.Float64())
+	}
+	return ttl
+}
+
+type cacheEntry struct {
+	identifier string
+	data       []byte
+	ttl        time.Duration
+	tags       []string
+	// markerKeys are stored along with the entry, see buildMarkerKeys
+	markerKeys []string
+	// start is the start of the query, see Config.StaleWriteProtection
+	start *queryStart
+}
+
+// storeInCache snapshots the result of the query, and writes it to the Cacher on the worker pool.
+// The snapshot is taken synchronously, as the caller owns Statement.Dest once the callback returns.
+func (c *Caches) storeInCache(db *gorm.DB, identifier string, start *queryStart) {
+	entries, err := c.snapshot(db, identifier, start)
 	if err != nil {
 		db.Logger.Error(db.Statement.Context, "[storeInCache - Serialize] %s", err)
 		return
 	}
 
-	if err := c.Conf.Cacher.Set(identifier, cachedData, c.Conf.CacheTTL); err != nil {
-		db.Logger.Error(db.Statement.Context, "[storeInCache - Store] %s", err)
+	logger, ctx := db.Logger, db.Statement.Context
+	c.async(func() {
+		c.setCache(logger, ctx, entries...)
+	})
+}
+
+// snapshot serializes the result of the query into a cache entry
+func (c *Caches) snapshot(db *gorm.DB, identifier string, start *queryStart) ([]*cacheEntry, error) {
+	ttl := c.cacheTTL()
+	now := time.Now()
+	dest, err := typedDest(db.Statement.Dest)
+	if err != nil {
+		return nil, err
+	}
+	query := Query{
+		Dest:         dest,
+		RowsAffected: db.Statement.RowsAffected,
+		Delta:        now.Sub(start.time),
+	}
+	if c.negativeResult(db) {
+		ttl = c.Conf.NegativeCacheTTL
+		query.NotFound = db.Error != nil
+	} else if _, graph := c.preloadGraph(db); c.staleWhileRevalidate() && !graph {
+		// preloaded graphs are not revalidated, as refreshes do not run the preloads
+		query.StaleAt = now.Add(c.Conf.CacheSoftTTL)
+	}
+	if ttl > 0 {
+		query.ExpiresAt = now.Add(ttl)
+	}
+
+	// ID lists go after their rows, so they do not reference rows missing from the cache
+	entries := make([]*cacheEntry, 0, 1)
+	if c.isIDListIdentifier(db, identifier) {
+		rowEntries, primaryKeys, err := c.snapshotRows(db, start)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, rowEntries...)
+		query.Dest = nil
+		query.IDList = true
+		query.PrimaryKeys = primaryKeys
+	}
+
+	cachedData, err := c.Conf.Serializer.Serialize(query)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{
+		identifier: identifier,
+		data:       cachedData,
+		ttl:        ttl,
+		markerKeys: c.buildMarkerKeys(db),
+		start:      start,
+	}
+	if _, ok := c.cacher().(TaggedCacher); ok {
+		entry.tags = c.buildTags(db)
+	}
+	return append(entries, entry), nil
+}
+
+// setCache stores the entries in order, batching the consecutive ones without tags nor markers sharing their TTL.
+// Under StaleWriteProtection, the entries of queries whose tables got invalidated since they started are discarded,
+// and deleted again if the invalidation lands while they are stored.
+func (c *Caches) setCache(log logger.Interface, ctx context.Context, entries ...*cacheEntry) {
+	stored := func(err error) bool {
+		if err != nil && !errors.Is(err, ErrCircuitOpen) {
+			log.Error(ctx, "[storeInCache - Store] %s", err)
+		}
+		return err == nil
+	}
+
+	var (
+		batch    = make(map[string][]byte)
+		batchTTL time.Duration
+		// entries of a snapshot share their start, checked once
+		stale   = make(map[*queryStart]bool)
+		written = make(map[*queryStart][]string)
+	)
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		vals := batch
+		batch = make(map[string][]byte)
+		return stored(setMulti(c.cacher(), vals, batchTTL))
+	}
+	defer func() {
+		for start, identifiers := range written {
+			if c.invalidatedSince(start) {
+				stored(deleteMulti(c.cacher(), identifiers))
+			}
+		}
+	}()
+
+	for _, entry := range entries {
+		if c.Conf.StaleWriteProtection && entry.start != nil {
+			invalidated, ok := stale[entry.start]
+			if !ok {
+				invalidated = c.invalidatedSince(entry.start)
+				stale[entry.start] = invalidated
+			}
+			if invalidated {
+				continue
+			}
+			written[entry.start] = append(written[entry.start], entry.identifier)
+		}
+
+		simple := len(entry.tags) == 0 && len(entry.markerKeys) == 0
+		if !simple || (len(batch) > 0 && entry.ttl != batchTTL) {
+			if !flush() {
+				return
+			}
+		}
+		if simple {
+			batch[entry.identifier] = entry.data
+			batchTTL = entry.ttl
+			continue
+		}
+
+		// the markers go first, so evicting a row while the entry is stored leaves it without its marker
+		if len(entry.markerKeys) > 0 {
+			markers := make(map[string][]byte, len(entry.markerKeys))
+			for _, markerKey := range entry.markerKeys {
+				markers[markerKey] = detailMarker
+			}
+			if !stored(setMulti(c.cacher(), markers, entry.ttl)) {
+				return
+			}
+		}
+
+		var err error
+		if tagged, ok := c.cacher().(TaggedCacher); ok && len(entry.tags) > 0 {
+			err = tagged.SetWithTags(entry.identifier, entry.data, entry.ttl, entry.tags)
+		} else {
+			err = c.cacher().Set(entry.identifier, entry.data, entry.ttl)
+		}
+		if !stored(err) {
+			return
+		}
+	}
+	flush()
+}
+
+func (c *Caches) syncInvalidation(ctx context.Context) bool {
+	if ctx != nil {
+		if enabled, ok := ctx.Value(SYNC_INVALIDATION_KEY).(bool); ok {
+			return enabled
+		}
 	}
+	return c.Conf.SyncInvalidation
 }
 
 func (c *Caches) ctxIgnoredCache(ctx context.Context) bool {
@@ -208,5 +954,5 @@ func (c *Caches) tableIgnoredCache(tableName string) bool {
 }
 
 func (c *Caches) ignoredCache(db *gorm.DB) bool {
-	return c.Conf.Cacher == nil || c.tableIgnoredCache(db.Statement.Table) || c.ctxIgnoredCache(db.Statement.Context)
+	return c.cacher() == nil || c.tableIgnoredCache(db.Statement.Table) || c.ctxIgnoredCache(db.Statement.Context)
 }
@@ -1,6 +1,8 @@
 package caches
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"sync"
@@ -337,6 +339,9 @@ func TestCaches_Query(t *testing.T) {
 			db2.Statement.SQL.WriteString(exampleQuery)
 
 			caches.Query(db1)
+			if err := caches.Flush(context.Background()); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
 			caches.Query(db2)
 
 			if db1.Error != nil {
@@ -397,3 +402,543 @@ func TestCaches_Query(t *testing.T) {
 		})
 	})
 }
+
+func TestCaches_StaleWhileRevalidate(t *testing.T) {
+	var incr int32
+	cacher := &cacherMock{}
+	caches := &Caches{
+		Conf: &Config{
+			Easer:        false,
+			Cacher:       cacher,
+			Serializer:   JSONSerializer{},
+			CacheTTL:     time.Minute,
+			CacheSoftTTL: time.Second,
+		},
+
+		queryCb: func(db *gorm.DB) {
+			atomic.AddInt32(&incr, 1)
+			db.Statement.Dest.(*mockDest).Result = "fresh"
+		},
+	}
+
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	db.Statement.Dest = &mockDest{}
+	db.Statement.SQL.WriteString("demo-query")
+	identifier := caches.buildIdentifier(db)
+
+	staleData, _ := JSONSerializer{}.Serialize(Query{
+		Dest:         &mockDest{Result: "stale"},
+		RowsAffected: 1,
+		StaleAt:      time.Now().Add(-time.Second),
+	})
+	_ = cacher.Set(identifier, staleData, time.Minute)
+
+	caches.Query(db)
+
+	if res := db.Statement.Dest.(*mockDest); res.Result != "stale" {
+		t.Errorf("expected the stale entry to be served, got `%s`", res.Result)
+	}
+
+	if err := caches.Flush(context.Background()); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	if act := atomic.LoadInt32(&incr); act != 1 {
+		t.Errorf("expected a single background refresh, but the query ran %d times", act)
+	}
+
+	var query Query
+	query.Dest = &mockDest{}
+	res, _ := cacher.Get(identifier)
+	if err := caches.Conf.Serializer.Deserialize(res, &query); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	if query.Dest.(*mockDest).Result != "fresh" {
+		t.Errorf("expected the refreshed entry to be stored, got `%s`", query.Dest.(*mockDest).Result)
+	}
+	if !query.StaleAt.After(time.Now()) {
+		t.Errorf("expected the refreshed entry to carry a future StaleAt, got %v", query.StaleAt)
+	}
+}
+
+func TestCaches_cacheTTL(t *testing.T) {
+	caches := &Caches{
+		Conf: &Config{
+			CacheTTL:       time.Minute,
+			CacheTTLJitter: 0.2,
+		},
+	}
+
+	for i := 0; i < 100; i++ {
+		if ttl := caches.cacheTTL(); ttl > time.Minute || ttl < 48*time.Second {
+			t.Fatalf("expected a jittered ttl between 48s and 1m, got %v", ttl)
+		}
+	}
+}
+
+func TestCaches_expiresEarly(t *testing.T) {
+	caches := &Caches{
+		Conf: &Config{
+			EarlyExpirationBeta: 1,
+		},
+	}
+
+	if caches.expiresEarly(Query{ExpiresAt: time.Now().Add(time.Hour), Delta: time.Millisecond}) {
+		t.Error("expected an entry far from its expiry not to be recomputed early")
+	}
+
+	if !caches.expiresEarly(Query{ExpiresAt: time.Now().Add(-time.Millisecond), Delta: time.Millisecond}) {
+		t.Error("expected an already expired entry to be recomputed")
+	}
+
+	caches.Conf.EarlyExpirationBeta = 0
+	if caches.expiresEarly(Query{ExpiresAt: time.Now().Add(-time.Millisecond), Delta: time.Millisecond}) {
+		t.Error("expected early expiration to be disabled without beta")
+	}
+}
+
+func TestCaches_NegativeCache(t *testing.T) {
+	var incr int32
+	caches := &Caches{
+		Conf: &Config{
+			Easer:            false,
+			Cacher:           &cacherMock{},
+			Serializer:       JSONSerializer{},
+			CacheTTL:         time.Minute,
+			NegativeCacheTTL: time.Second,
+		},
+
+		queryCb: func(db *gorm.DB) {
+			atomic.AddInt32(&incr, 1)
+			_ = db.AddError(gorm.ErrRecordNotFound)
+		},
+	}
+
+	db1, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	db1.Statement.Dest = &mockDest{}
+	db1.Statement.SQL.WriteString("demo-query")
+	db2, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	db2.Statement.Dest = &mockDest{}
+	db2.Statement.SQL.WriteString("demo-query")
+
+	caches.Query(db1)
+	if !errors.Is(db1.Error, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected the query to fail with `record not found`, got %v", db1.Error)
+	}
+
+	if err := caches.Flush(context.Background()); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	caches.Query(db2)
+	if !errors.Is(db2.Error, gorm.ErrRecordNotFound) {
+		t.Errorf("expected the negative entry to replay `record not found`, got %v", db2.Error)
+	}
+
+	if act := atomic.LoadInt32(&incr); act != 1 {
+		t.Errorf("expected the negative entry to be served from cache, but the query ran %d times", act)
+	}
+}
+
+func TestCaches_AfterUpdate_SyncInvalidation(t *testing.T) {
+	t.Run("config", func(t *testing.T) {
+		cacher := &cacherDeleteMock{}
+		caches := &Caches{
+			Conf: &Config{
+				Cacher:           cacher,
+				InstanceId:       "123",
+				SyncInvalidation: true,
+			},
+		}
+
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Table = "users"
+		caches.AfterUpdate(db)
+
+		// without primary keys, the whole table is evicted
+		expected := []string{"INSTANCE_123:TABLE_users:"}
+		if actual := cacher.deletedPrefixes(); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("AfterUpdate expected to evict %v before returning, evicted %v", expected, actual)
+		}
+	})
+
+	t.Run("many rows", func(t *testing.T) {
+		cacher := &cacherDeleteMock{}
+		caches := &Caches{
+			Conf: &Config{
+				Cacher:           cacher,
+				InstanceId:       "123",
+				SyncInvalidation: true,
+			},
+		}
+
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+
+		ids := make([]uint, 0, MAX_DETAIL_KEYS+1)
+		users := make([]mockUser, 0, MAX_DETAIL_KEYS+1)
+		for id := uint(1); id <= MAX_DETAIL_KEYS+1; id++ {
+			ids = append(ids, id)
+			users = append(users, mockUser{ID: id})
+		}
+
+		// past MAX_DETAIL_KEYS rows, the whole table is evicted rather than every row
+		expected := []string{"INSTANCE_123:TABLE_mock_users:"}
+		for name, write := range map[string]func(){
+			"update": func() { db.Model(&mockUser{}).Where("id IN ?", ids).Update("name", "john") },
+			"delete": func() { db.Where("id IN ?", ids).Delete(&mockUser{}) },
+			"create": func() { db.Create(&users) },
+		} {
+			cacher.mu.Lock()
+			cacher.prefixes = nil
+			cacher.mu.Unlock()
+
+			write()
+			if actual := cacher.deletedPrefixes(); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%s expected to evict %v, evicted %v", name, expected, actual)
+			}
+		}
+	})
+
+	t.Run("context override", func(t *testing.T) {
+		cacher := &cacherDeleteMock{}
+		caches := &Caches{
+			Conf: &Config{
+				Cacher:     cacher,
+				InstanceId: "123",
+			},
+		}
+
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db = db.WithContext(WithSyncInvalidation(context.Background(), true))
+		db.Statement.Table = "users"
+		caches.AfterUpdate(db)
+
+		if actual := cacher.deletedPrefixes(); len(actual) != 1 {
+			t.Errorf("AfterUpdate expected to evict synchronously, evicted %v", actual)
+		}
+	})
+
+	t.Run("error policy", func(t *testing.T) {
+		errDelete := errors.New("delete-error")
+		caches := &Caches{
+			Conf: &Config{
+				Cacher:                  &cacherDeleteMock{err: errDelete},
+				SyncInvalidation:        true,
+				InvalidationErrorPolicy: ErrorPolicyAddError,
+			},
+		}
+
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Table = "users"
+		caches.AfterUpdate(db)
+
+		if !errors.Is(db.Error, errDelete) {
+			t.Errorf("AfterUpdate expected to add the Cacher error to the statement, got %v", db.Error)
+		}
+	})
+}
+
+// Run with -race, the caller mutating its result must not race with the deferred cache write
+func TestCaches_Query_SnapshotBeforeStore(t *testing.T) {
+	cacher := &cacherMock{}
+	caches := &Caches{
+		Conf: &Config{
+			Easer:      false,
+			Cacher:     cacher,
+			Serializer: JSONSerializer{},
+		},
+
+		queryCb: func(db *gorm.DB) {
+			db.Statement.Dest.(*mockDest).Result = "from-db"
+		},
+	}
+
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	db.Statement.Dest = &mockDest{}
+	db.Statement.SQL.WriteString("demo-query")
+	identifier := caches.buildIdentifier(db)
+
+	caches.Query(db)
+	db.Statement.Dest.(*mockDest).Result = "mutated-by-caller"
+
+	if err := caches.Flush(context.Background()); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	query := Query{Dest: &mockDest{}}
+	res, _ := cacher.Get(identifier)
+	if err := caches.Conf.Serializer.Deserialize(res, &query); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	if query.Dest.(*mockDest).Result != "from-db" {
+		t.Errorf("expected the query result to be cached, got `%s`", query.Dest.(*mockDest).Result)
+	}
+}
+
+func TestCaches_StaleWriteProtection(t *testing.T) {
+	cacher := &cacherMock{}
+	caches := &Caches{
+		Conf: &Config{
+			Easer:                false,
+			Cacher:               cacher,
+			Serializer:           JSONSerializer{},
+			StaleWriteProtection: true,
+			SyncInvalidation:     true,
+		},
+	}
+
+	writer, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	writer.Statement.Table = "users"
+
+	// the row gets updated while the reader is querying the database
+	caches.queryCb = func(db *gorm.DB) {
+		db.Statement.Dest.(*mockDest).Result = "old"
+		caches.AfterUpdate(writer)
+	}
+
+	reader, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	reader.Statement.Dest = &mockDest{}
+	reader.Statement.Table = "users"
+	reader.Statement.SQL.WriteString("demo-query")
+	identifier := caches.buildIdentifier(reader)
+
+	caches.Query(reader)
+	if err := caches.Flush(context.Background()); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	if res, _ := cacher.Get(identifier); res != nil {
+		t.Error("expected the store started before the invalidation to be discarded")
+	}
+
+	// queries started after the invalidation are cached again
+	caches.queryCb = func(db *gorm.DB) {
+		db.Statement.Dest.(*mockDest).Result = "new"
+	}
+	caches.Query(reader)
+	if err := caches.Flush(context.Background()); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	if res, _ := cacher.Get(identifier); res == nil {
+		t.Error("expected the store started after the invalidation to be cached")
+	}
+}
+
+// cacherSetHookMock runs the hook once, right before storing the given key
+type cacherSetHookMock struct {
+	cacherMock
+	key  string
+	hook func()
+	once sync.Once
+}
+
+func (c *cacherSetHookMock) Set(key string, val []byte, ttl time.Duration) error {
+	if key == c.key {
+		c.once.Do(c.hook)
+	}
+	return c.cacherMock.Set(key, val, ttl)
+}
+
+func TestCaches_StaleWriteProtection_racingInvalidation(t *testing.T) {
+	for name, invalidate := range map[string]func(caches *Caches){
+		"update": func(caches *Caches) {
+			writer, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			writer.Statement.Table = "users"
+			caches.AfterUpdate(writer)
+		},
+		"invalidate all": func(caches *Caches) {
+			_ = caches.InvalidateAll(context.Background())
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cacher := &cacherSetHookMock{}
+			caches := &Caches{
+				Conf: &Config{
+					Easer:                false,
+					Cacher:               cacher,
+					Serializer:           JSONSerializer{},
+					StaleWriteProtection: true,
+					SyncInvalidation:     true,
+				},
+				queryCb: func(db *gorm.DB) {
+					db.Statement.Dest.(*mockDest).Result = "old"
+				},
+			}
+
+			reader, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			reader.Statement.Dest = &mockDest{}
+			reader.Statement.Table = "users"
+			reader.Statement.SQL.WriteString("demo-query")
+			identifier := caches.buildIdentifier(reader)
+
+			// the table gets invalidated between the tombstone check and the store
+			cacher.key = identifier
+			cacher.hook = func() {
+				invalidate(caches)
+			}
+
+			caches.Query(reader)
+			if err := caches.Flush(context.Background()); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+
+			if res, _ := cacher.Get(identifier); res != nil {
+				t.Error("expected the store racing with the invalidation to be deleted")
+			}
+		})
+	}
+}
+
+func TestCaches_Query_FailurePolicy(t *testing.T) {
+	errCacher := errors.New("cacher-down")
+	newCaches := func(policy FailurePolicy) *Caches {
+		return &Caches{
+			Conf: &Config{
+				Cacher:              &cacherFailingMock{err: errCacher},
+				Serializer:          JSONSerializer{},
+				CacherFailurePolicy: policy,
+			},
+			queryCb: func(db *gorm.DB) {
+				db.Statement.Dest.(*mockDest).Result = "from-db"
+			},
+		}
+	}
+
+	t.Run("fail open", func(t *testing.T) {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		newCaches(FailOpen).Query(db)
+
+		if db.Error != nil || db.Statement.Dest.(*mockDest).Result != "from-db" {
+			t.Errorf("expected the query to fall back to the database, got %v", db.Error)
+		}
+	})
+
+	t.Run("fail closed", func(t *testing.T) {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		newCaches(FailClosed).Query(db)
+
+		if !errors.Is(db.Error, errCacher) || db.Statement.Dest.(*mockDest).Result != "" {
+			t.Errorf("expected the query to fail with the cacher error, got %v", db.Error)
+		}
+	})
+}
+
+func TestCaches_InvalidateTags(t *testing.T) {
+	cacher := NewMemoryCacher(0)
+	caches := &Caches{
+		Conf: &Config{
+			InstanceId: "123",
+			Cacher:     cacher,
+			Serializer: JSONSerializer{},
+		},
+		queryCb: func(db *gorm.DB) {
+			db.Statement.Dest.(*mockDest).Result = "from-db"
+		},
+	}
+
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	db = db.WithContext(WithTags(context.Background(), "tenant:42"))
+	db.Statement.Dest = &mockDest{}
+	db.Statement.Table = "users"
+	db.Statement.SQL.WriteString("demo-query")
+	identifier := caches.buildIdentifier(db)
+
+	caches.Query(db)
+	if err := caches.Flush(context.Background()); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	for _, tag := range []string{TableTag("users"), "tenant:42"} {
+		if _, ok := cacher.tags[GenTagKey("123", tag)][identifier]; !ok {
+			t.Errorf("expected the entry to be tagged with `%s`, tags %v", tag, cacher.tags)
+		}
+	}
+
+	if err := caches.InvalidateTags(context.Background(), "tenant:42"); err != nil {
+		t.Fatalf("InvalidateTags returned an unexpected error %v", err)
+	}
+	if val, _ := cacher.Get(identifier); val != nil {
+		t.Error("InvalidateTags expected to evict the tagged entry")
+	}
+
+	caches.Conf.Cacher = &cacherMock{}
+	if err := caches.InvalidateTags(context.Background(), "tenant:42"); err != ErrTagsUnsupported {
+		t.Errorf("InvalidateTags expected to return %v, got %v", ErrTagsUnsupported, err)
+	}
+}
+
+func TestCaches_DetailKeys(t *testing.T) {
+	var incr int32
+	caches := &Caches{
+		Conf: &Config{
+			Easer:            false,
+			Cacher:           NewMemoryCacher(0),
+			Serializer:       JSONSerializer{},
+			SyncInvalidation: true,
+		},
+
+		queryCb: func(db *gorm.DB) {
+			atomic.AddInt32(&incr, 1)
+		},
+	}
+
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	query := func() {
+		tx := db.Session(&gorm.Session{NewDB: true}).Model(&mockUser{}).Where("id IN ?", []int{1, 2})
+		if err := tx.Statement.Parse(&mockUser{}); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		tx.Statement.Dest = &[]mockUser{}
+		caches.Query(tx)
+		if err := caches.Flush(context.Background()); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+	}
+	write := func(callback func(*gorm.DB), scope func(*gorm.DB) *gorm.DB) {
+		tx := scope(db.Session(&gorm.Session{NewDB: true}).Model(&mockUser{}))
+		if err := tx.Statement.Parse(&mockUser{}); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		callback(tx)
+	}
+
+	query()
+	query()
+	if act := atomic.LoadInt32(&incr); act != 1 {
+		t.Fatalf("expected the query to be served from cache, but it ran %d times", act)
+	}
+
+	// creating an unrelated row keeps the entry
+	write(caches.AfterCreate, func(tx *gorm.DB) *gorm.DB {
+		tx.Statement.ReflectValue = reflect.ValueOf(&mockUser{ID: 3}).Elem()
+		return tx
+	})
+	query()
+	if act := atomic.LoadInt32(&incr); act != 1 {
+		t.Errorf("expected the entry to survive the creation of another row, but the query ran %d times", act)
+	}
+
+	// updating the second row evicts the entry through its marker
+	write(caches.AfterUpdate, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("id = ?", 2)
+	})
+	query()
+	if act := atomic.LoadInt32(&incr); act != 2 {
+		t.Errorf("expected the entry to be evicted by the update of one of its rows, but the query ran %d times", act)
+	}
+
+	// updating rows out of their primary keys may concern any of them
+	write(caches.AfterUpdate, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("name = ?", "a")
+	})
+	query()
+	if act := atomic.LoadInt32(&incr); act != 3 {
+		t.Errorf("expected the entry to be evicted by an update without primary keys, but the query ran %d times", act)
+	}
+}
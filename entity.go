@@ -0,0 +1,79 @@
+package caches
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// getEntityKey returns the primary key of the row selected by a single-row lookup (First, Take or Last)
+// filtered by primary key only and loading the whole row into its model, empty for any other statement.
+// Such lookups share the entry of the row however they are written, see Config.EntityCache.
+func getEntityKey(db *gorm.DB) string {
+	stmt := db.Statement
+	if !loadsWholeRows(db) || !stmt.RaiseErrorOnNotFound {
+		return ""
+	}
+
+	// skipping rows, the lookup finds none however its row is cached
+	if limit, ok := stmt.Clauses["LIMIT"].Expression.(clause.Limit); ok && limit.Offset != 0 {
+		return ""
+	}
+
+	if destType := reflect.TypeOf(stmt.Dest); destType == nil || destType.Kind() != reflect.Ptr ||
+		destType.Elem() != stmt.Schema.ModelType {
+		return ""
+	}
+
+	primaryKeys, others := getWhereConditions(db)
+	if len(primaryKeys) != 1 {
+		return ""
+	}
+
+	// the soft delete condition is the only one allowed besides the primary key
+	for _, expr := range others {
+		eqExpr, ok := expr.(clause.Eq)
+		if !ok {
+			return ""
+		}
+		field := stmt.Schema.LookUpField(getColNameFromColumn(eqExpr.Column))
+		if field == nil || field.FieldType != deletedAtType {
+			return ""
+		}
+	}
+
+	return primaryKeys[0]
+}
+
+// loadsWholeRows reports whether the statement loads whole rows of its model's table, as stored in row entries.
+// Unscoped statements of soft deleted models do not, as they load rows hidden from the others.
+func loadsWholeRows(db *gorm.DB) bool {
+	stmt := db.Statement
+	if stmt.Schema == nil || stmt.Table != stmt.Schema.Table {
+		return false
+	}
+
+	if len(stmt.Selects) > 0 || len(stmt.Omits) > 0 || len(stmt.Joins) > 0 || len(stmt.Preloads) > 0 || stmt.Distinct {
+		return false
+	}
+	for _, name := range []string{"GROUP BY", "FOR"} {
+		if _, ok := stmt.Clauses[name]; ok {
+			return false
+		}
+	}
+	if from, ok := stmt.Clauses["FROM"].Expression.(clause.From); ok && (len(from.Tables) > 0 || len(from.Joins) > 0) {
+		return false
+	}
+
+	if stmt.Unscoped {
+		for _, field := range stmt.Schema.Fields {
+			if field.FieldType == deletedAtType {
+				return false
+			}
+		}
+	}
+	return true
+}
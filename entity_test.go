@@ -0,0 +1,157 @@
+package caches
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/utils/tests"
+)
+
+type mockSoftDeletedUser struct {
+	ID        uint
+	Name      string
+	DeletedAt gorm.DeletedAt
+}
+
+func Test_getEntityKey(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+
+	var entityKey string
+	_ = db.Callback().Query().Before("gorm:query").Register("test:entity_key", func(tx *gorm.DB) {
+		callbacks.BuildQuerySQL(tx)
+		entityKey = getEntityKey(tx)
+	})
+
+	var (
+		user        mockUser
+		users       []mockUser
+		softDeleted mockSoftDeletedUser
+		dest        mockDest
+	)
+	for name, tc := range map[string]struct {
+		query    func(*gorm.DB)
+		expected string
+	}{
+		"first by primary key":      {query: func(tx *gorm.DB) { tx.First(&user, 5) }, expected: "5"},
+		"take by condition":         {query: func(tx *gorm.DB) { tx.Take(&user, "id = ?", 5) }, expected: "5"},
+		"where then first":          {query: func(tx *gorm.DB) { tx.Where("id", 5).First(&user) }, expected: "5"},
+		"last by struct":            {query: func(tx *gorm.DB) { tx.Where(&mockUser{ID: 5}).Last(&user) }, expected: "5"},
+		"soft deleted model":        {query: func(tx *gorm.DB) { tx.First(&softDeleted, 5) }, expected: "5"},
+		"unscoped":                  {query: func(tx *gorm.DB) { tx.Unscoped().First(&softDeleted, 5) }},
+		"find":                      {query: func(tx *gorm.DB) { tx.Find(&user, 5) }},
+		"several rows":              {query: func(tx *gorm.DB) { tx.First(&users, []int{5, 6}) }},
+		"other condition":           {query: func(tx *gorm.DB) { tx.Where("name = ?", "john").First(&user, 5) }},
+		"not scoped by primary key": {query: func(tx *gorm.DB) { tx.First(&user, "name = ?", "john") }},
+		"offset":                    {query: func(tx *gorm.DB) { tx.Offset(1).First(&user, 5) }},
+		"selected columns":          {query: func(tx *gorm.DB) { tx.Select("name").First(&user, 5) }},
+		"other destination":         {query: func(tx *gorm.DB) { tx.Model(&mockUser{}).First(&dest, 5) }},
+		"other table":               {query: func(tx *gorm.DB) { tx.Table("archived_users").First(&user, 5) }},
+	} {
+		t.Run(name, func(t *testing.T) {
+			entityKey = ""
+			tc.query(db.Session(&gorm.Session{NewDB: true}))
+			if entityKey != tc.expected {
+				t.Errorf("getEntityKey expected to return `%s` but got `%s`", tc.expected, entityKey)
+			}
+		})
+	}
+}
+
+func TestCaches_EntityCache(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	caches := &Caches{
+		Conf: &Config{
+			Cacher:           NewMemoryCacher(0),
+			Serializer:       JSONSerializer{},
+			SyncInvalidation: true,
+			EntityCache:      true,
+		},
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	var incr int32
+	caches.queryCb = func(tx *gorm.DB) {
+		atomic.AddInt32(&incr, 1)
+		tx.Statement.Dest.(*mockUser).ID = 5
+		tx.Statement.Dest.(*mockUser).Name = "john"
+		tx.Statement.RowsAffected = 1
+	}
+	lookups := []func(*mockUser){
+		func(user *mockUser) { db.First(user, 5) },
+		func(user *mockUser) { db.Take(user, "id = ?", 5) },
+		func(user *mockUser) { db.Where("id", 5).First(user) },
+	}
+
+	for _, lookup := range lookups {
+		var user mockUser
+		lookup(&user)
+		if user.Name != "john" {
+			t.Errorf("expected the lookup to return the row, got %+v", user)
+		}
+		if err := caches.Flush(context.Background()); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+	}
+	if act := atomic.LoadInt32(&incr); act != 1 {
+		t.Errorf("expected every lookup to share the entry of the row, but the query ran %d times", act)
+	}
+
+	// updating the row evicts its entry
+	db.Model(&mockUser{ID: 5}).Update("name", "jane")
+	lookups[0](&mockUser{})
+	if act := atomic.LoadInt32(&incr); act != 2 {
+		t.Errorf("expected the entry to be evicted by the update of its row, but the query ran %d times", act)
+	}
+}
+
+func TestCaches_EntityCache_unparsedDelete(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	cacher := NewMemoryCacher(0)
+	caches := &Caches{
+		Conf: &Config{
+			InstanceId:       "1",
+			Cacher:           cacher,
+			Serializer:       JSONSerializer{},
+			SyncInvalidation: true,
+			EntityCache:      true,
+		},
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	caches.queryCb = func(tx *gorm.DB) {
+		if user, ok := tx.Statement.Dest.(*mockUser); ok {
+			user.ID = 5
+			tx.Statement.RowsAffected = 1
+		}
+	}
+
+	rowKey := GenCacheKey("1", "mock_users", "5")
+	for name, deletion := range map[string]func(){
+		"subquery":   func() { db.Where("id IN (?)", db.Model(&mockUser{}).Select("id")).Delete(&mockUser{}) },
+		"expression": func() { db.Where("id = ?", gorm.Expr("(SELECT 5)")).Delete(&mockUser{}) },
+		"clause":     func() { db.Where(clause.Eq{Column: "id", Value: gorm.Expr("(SELECT 5)")}).Delete(&mockUser{}) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			db.First(&mockUser{}, 5)
+			if err := caches.Flush(context.Background()); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+			if val, _ := cacher.Get(rowKey); val == nil {
+				t.Fatalf("expected the row to be cached under `%s`", rowKey)
+			}
+
+			// the deleted rows are unknown, the whole table is evicted
+			deletion()
+			if val, _ := cacher.Get(rowKey); val != nil {
+				t.Errorf("expected the row entry to be evicted by the deletion, got `%s`", val)
+			}
+		})
+	}
+}
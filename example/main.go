@@ -76,6 +76,7 @@ func main() {
 	}}
 
 	_ = db.Use(cachesPlugin)
+	defer cachesPlugin.Close()
 
 	_ = db.AutoMigrate(&UserRoleModel{})
 
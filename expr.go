@@ -0,0 +1,271 @@
+package caches
+
+import (
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm/clause"
+)
+
+type exprTokenKind int
+
+const (
+	tokenIdent       exprTokenKind = iota // bare or quoted identifier, or keyword
+	tokenString                           // 'string literal'
+	tokenNumber                           // numeric literal
+	tokenPlaceholder                      // ?
+	tokenPunct                            // = ( ) , .
+)
+
+type exprToken struct {
+	kind   exprTokenKind
+	text   string
+	quoted bool
+}
+
+// exprCondition is a `column = value` or `column IN (values)` condition of a SQL fragment
+type exprCondition struct {
+	// Column is the unquoted column name, without its table
+	Column string
+	Values []string
+}
+
+// tokenizeExpr splits a SQL fragment into tokens, it reports false on anything it does not understand
+func tokenizeExpr(sql string) ([]exprToken, bool) {
+	tokens := make([]exprToken, 0)
+	runes := []rune(sql)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '?':
+			tokens = append(tokens, exprToken{kind: tokenPlaceholder, text: "?"})
+			i++
+		case r == '=' || r == '(' || r == ')' || r == ',' || r == '.':
+			tokens = append(tokens, exprToken{kind: tokenPunct, text: string(r)})
+			i++
+		case r == '"' || r == '`' || r == '[' || r == '\'':
+			closing := r
+			if r == '[' {
+				closing = ']'
+			}
+
+			var text strings.Builder
+			j := i + 1
+			for ; j < len(runes); j++ {
+				if runes[j] == closing {
+					// doubled quotes escape themselves
+					if j+1 < len(runes) && runes[j+1] == closing && closing != ']' {
+						text.WriteRune(closing)
+						j++
+						continue
+					}
+					break
+				}
+				text.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, false
+			}
+
+			kind := tokenIdent
+			if r == '\'' {
+				kind = tokenString
+			}
+			tokens = append(tokens, exprToken{kind: kind, text: text.String(), quoted: true})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '$') {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, text: string(runes[i:j])})
+			i = j
+		default:
+			return nil, false
+		}
+	}
+	return tokens, true
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	vars   []interface{}
+	varPos int
+}
+
+// parseExprConditions analyses a SQL fragment made of `column = value` and `column IN (values)` conditions
+// joined by AND, binding its placeholders to the expression vars. It reports false for any other fragment.
+func parseExprConditions(expr clause.Expr) ([]exprCondition, bool) {
+	tokens, ok := tokenizeExpr(expr.SQL)
+	if !ok || len(tokens) == 0 {
+		return nil, false
+	}
+
+	p := &exprParser{tokens: tokens, vars: expr.Vars}
+	conditions, ok := p.parseConditions()
+	if !ok || p.pos != len(p.tokens) || p.varPos != len(p.vars) {
+		return nil, false
+	}
+	return conditions, true
+}
+
+func (p *exprParser) parseConditions() ([]exprCondition, bool) {
+	conditions := make([]exprCondition, 0)
+	for {
+		var (
+			parsed []exprCondition
+			ok     bool
+		)
+		if p.acceptPunct("(") {
+			// parenthesized group of ANDed conditions
+			parsed, ok = p.parseConditions()
+			if !ok || !p.acceptPunct(")") {
+				return nil, false
+			}
+		} else {
+			var condition exprCondition
+			condition, ok = p.parseCondition()
+			if !ok {
+				return nil, false
+			}
+			parsed = []exprCondition{condition}
+		}
+		conditions = append(conditions, parsed...)
+
+		if !p.acceptKeyword("AND") {
+			return conditions, true
+		}
+	}
+}
+
+func (p *exprParser) parseCondition() (exprCondition, bool) {
+	column, ok := p.parseColumn()
+	if !ok {
+		return exprCondition{}, false
+	}
+
+	if p.acceptPunct("=") {
+		values, ok := p.parseValue(false)
+		if !ok || len(values) != 1 {
+			return exprCondition{}, false
+		}
+		return exprCondition{Column: column, Values: values}, true
+	}
+
+	if !p.acceptKeyword("IN") {
+		return exprCondition{}, false
+	}
+
+	// `column IN ?` binds a slice directly
+	if !p.acceptPunct("(") {
+		values, ok := p.parseValue(true)
+		return exprCondition{Column: column, Values: values}, ok
+	}
+
+	condition := exprCondition{Column: column}
+	for {
+		values, ok := p.parseValue(true)
+		if !ok {
+			return exprCondition{}, false
+		}
+		condition.Values = append(condition.Values, values...)
+
+		if p.acceptPunct(")") {
+			return condition, true
+		}
+		if !p.acceptPunct(",") {
+			return exprCondition{}, false
+		}
+	}
+}
+
+// parseColumn reads a possibly table-qualified column, returning its name
+func (p *exprParser) parseColumn() (string, bool) {
+	var name string
+	for {
+		token, ok := p.next()
+		if !ok || token.kind != tokenIdent || (!token.quoted && isExprKeyword(token.text)) {
+			return "", false
+		}
+		name = token.text
+
+		if !p.acceptPunct(".") {
+			return name, true
+		}
+	}
+}
+
+// parseValue reads a literal or a placeholder, expanding slices bound to placeholders if allowed
+func (p *exprParser) parseValue(expandSlice bool) ([]string, bool) {
+	token, ok := p.next()
+	if !ok {
+		return nil, false
+	}
+
+	switch token.kind {
+	case tokenNumber, tokenString:
+		return []string{token.text}, true
+	case tokenPlaceholder:
+		if p.varPos >= len(p.vars) {
+			return nil, false
+		}
+		v := p.vars[p.varPos]
+		p.varPos++
+
+		// vars not binding scalars, e.g. subqueries, are not understood
+		values, ok := scalarKeyValues(v)
+		if !ok {
+			return nil, false
+		}
+		if len(values) != 1 && !expandSlice {
+			return nil, false
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+func (p *exprParser) next() (exprToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return exprToken{}, false
+	}
+	p.pos++
+	return p.tokens[p.pos-1], true
+}
+
+func (p *exprParser) acceptPunct(punct string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenPunct && p.tokens[p.pos].text == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) acceptKeyword(keyword string) bool {
+	if p.pos < len(p.tokens) {
+		token := p.tokens[p.pos]
+		if token.kind == tokenIdent && !token.quoted && strings.EqualFold(token.text, keyword) {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func isExprKeyword(text string) bool {
+	switch strings.ToUpper(text) {
+	case "AND", "OR", "IN", "NOT", "IS", "NULL", "LIKE", "BETWEEN":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package caches
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type stringerMock [2]byte
+
+func (s stringerMock) String() string {
+	return "stringer"
+}
+
+func Test_parseExprConditions(t *testing.T) {
+	for _, tc := range []struct {
+		sql      string
+		vars     []interface{}
+		expected []exprCondition
+		ok       bool
+	}{
+		// equality
+		{sql: "id = ?", vars: []interface{}{5}, expected: []exprCondition{{Column: "id", Values: []string{"5"}}}, ok: true},
+		{sql: "id=?", vars: []interface{}{5}, expected: []exprCondition{{Column: "id", Values: []string{"5"}}}, ok: true},
+		{sql: "id = 5", expected: []exprCondition{{Column: "id", Values: []string{"5"}}}, ok: true},
+		{sql: "code = 'a''b'", expected: []exprCondition{{Column: "code", Values: []string{"a'b"}}}, ok: true},
+		{sql: "min_price = ?", vars: []interface{}{10}, expected: []exprCondition{{Column: "min_price", Values: []string{"10"}}}, ok: true},
+		{sql: "brand = ?", vars: []interface{}{"acme"}, expected: []exprCondition{{Column: "brand", Values: []string{"acme"}}}, ok: true},
+		{sql: "uuid = ?", vars: []interface{}{stringerMock{1, 2}}, expected: []exprCondition{{Column: "uuid", Values: []string{"stringer"}}}, ok: true},
+		{sql: "id = ?", vars: []interface{}{sql.NullInt64{Int64: 5, Valid: true}}, expected: []exprCondition{{Column: "id", Values: []string{"5"}}}, ok: true},
+		{sql: "code = ?", vars: []interface{}{sql.NullString{String: "a", Valid: true}}, expected: []exprCondition{{Column: "code", Values: []string{"a"}}}, ok: true},
+
+		// quoted identifiers and table prefixes
+		{sql: "`users`.`id` = ?", vars: []interface{}{5}, expected: []exprCondition{{Column: "id", Values: []string{"5"}}}, ok: true},
+		{sql: `"public"."users"."id" = ?`, vars: []interface{}{5}, expected: []exprCondition{{Column: "id", Values: []string{"5"}}}, ok: true},
+		{sql: "[users].[id] = ?", vars: []interface{}{5}, expected: []exprCondition{{Column: "id", Values: []string{"5"}}}, ok: true},
+		{sql: "`order` = ?", vars: []interface{}{1}, expected: []exprCondition{{Column: "order", Values: []string{"1"}}}, ok: true},
+
+		// IN
+		{sql: "origin_id in (?)", vars: []interface{}{[]int{1, 2}}, expected: []exprCondition{{Column: "origin_id", Values: []string{"1", "2"}}}, ok: true},
+		{sql: "id IN ?", vars: []interface{}{[]int{1, 2}}, expected: []exprCondition{{Column: "id", Values: []string{"1", "2"}}}, ok: true},
+		{sql: "id IN (?, ?)", vars: []interface{}{1, 2}, expected: []exprCondition{{Column: "id", Values: []string{"1", "2"}}}, ok: true},
+		{sql: "id IN (1, 2,3)", expected: []exprCondition{{Column: "id", Values: []string{"1", "2", "3"}}}, ok: true},
+		{sql: "users.id IN ?", vars: []interface{}{[]string{"a"}}, expected: []exprCondition{{Column: "id", Values: []string{"a"}}}, ok: true},
+
+		// AND chains
+		{sql: "tenant_id = ? AND id = ?", vars: []interface{}{42, 7}, expected: []exprCondition{
+			{Column: "tenant_id", Values: []string{"42"}},
+			{Column: "id", Values: []string{"7"}},
+		}, ok: true},
+		{sql: "(tenant_id = ?) and (id in (?))", vars: []interface{}{42, []int{7, 8}}, expected: []exprCondition{
+			{Column: "tenant_id", Values: []string{"42"}},
+			{Column: "id", Values: []string{"7", "8"}},
+		}, ok: true},
+
+		// unsupported
+		{sql: "id = ? OR id = ?", vars: []interface{}{1, 2}},
+		{sql: "id > ?", vars: []interface{}{1}},
+		{sql: "id != ?", vars: []interface{}{1}},
+		{sql: "id NOT IN (?)", vars: []interface{}{[]int{1}}},
+		{sql: "id = ?", vars: []interface{}{[]int{1, 2}}},
+		{sql: "id = ?"},
+		{sql: "id = ?", vars: []interface{}{sql.NullInt64{}}},
+		{sql: "id = ?", vars: []interface{}{1, 2}},
+		{sql: "id IN (?)", vars: []interface{}{&gorm.DB{}}},
+		{sql: "id = ?", vars: []interface{}{gorm.Expr("(SELECT 5)")}},
+		{sql: "id = ?", vars: []interface{}{clause.Expr{SQL: "(SELECT 5)"}}},
+		{sql: "id = ?", vars: []interface{}{mockUser{ID: 5}}},
+		{sql: "id IN ?", vars: []interface{}{[]interface{}{5, gorm.Expr("6")}}},
+		{sql: "LOWER(name) = ?", vars: []interface{}{"a"}},
+		{sql: "name LIKE ?", vars: []interface{}{"a%"}},
+		{sql: "id IS NULL"},
+		{sql: "code = 'unterminated"},
+		{sql: ""},
+	} {
+		actual, ok := parseExprConditions(clause.Expr{SQL: tc.sql, Vars: tc.vars})
+		if ok != tc.ok || (ok && !reflect.DeepEqual(actual, tc.expected)) {
+			t.Errorf("parseExprConditions(%q, %v) expected to return %v, %t but got %v, %t", tc.sql, tc.vars, tc.expected, tc.ok, actual, ok)
+		}
+	}
+}
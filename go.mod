@@ -1,5 +1,17 @@
 module github.com/truanguyenvan/gorm-caches/v2
 
-go 1.19
+go 1.23
 
-require gorm.io/gorm v1.25.0
\ No newline at end of file
+require (
+	github.com/goccy/go-json v0.11.2
+	gorm.io/driver/mysql v1.6.0
+	gorm.io/gorm v1.30.0
+)
+
+require (
+	filippo.io/edwards25519 v1.1.0 // indirect
+	github.com/go-sql-driver/mysql v1.8.1 // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	golang.org/x/text v0.20.0 // indirect
+)
@@ -1,51 +1,97 @@
 package caches
 
 import (
-	"encoding/base64"
+	"database/sql/driver"
 	"fmt"
 	"gorm.io/gorm/callbacks"
 	"gorm.io/gorm/clause"
 	"reflect"
-	"strconv"
 	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 const (
-	CACHE_PATTERN = "INSTANCE_%s:TABLE_%s:%s" //instanceId:tableName:keyCache
+	CACHE_PATTERN    = "INSTANCE_%s:TABLE_%s:%s" //instanceId:tableName:keyCache
+	INSTANCE_PATTERN = "INSTANCE_%s:"            //instanceId
+
+	TOMBSTONE_TTL = 10 * time.Minute
 
 	LIST_KEY = "LIST"
+	IDS_KEY  = "IDS" // ID-list entries, see Config.IDListCache
+
+	COMPOSITE_KEY_SEPARATOR = "," // between the values of a composite primary key
+
+	// MAX_DETAIL_KEYS bounds the number of rows a query is keyed under, larger queries are keyed as lists
+	MAX_DETAIL_KEYS = 100
 )
 
-func hashKey(key string) string {
-	return base64.StdEncoding.EncodeToString([]byte(key))
-}
+// tombstonePrefix is prepended to the table and instance prefixes of the KeyBuilder to build tombstone keys
+const tombstonePrefix = "TOMBSTONE:"
+
+// detailMarker is the value of the keys marking the other rows held by a detail entry, see buildMarkerKeys
+var detailMarker = []byte("1")
 
 func (c *Caches) buildIdentifier(db *gorm.DB) string {
 	// Build query identifier,
 	//	for that reason we need to compile all arguments into a string
 	//	and concat them with the SQL query itself
-	var (
-		tableName string
-		keys      []string
-	)
+	// building the statement adds its query clauses, e.g. the soft delete condition
+	callbacks.BuildQuerySQL(db)
+	tableName := c.statementTable(db)
+	if _, ok := c.preloadGraph(db); ok {
+		// preloaded graphs are evicted along with the tables of their preloads, see buildMarkerKeys
+		return c.keys().QueryKey(c.Conf.InstanceId, tableName, LIST_KEY, c.queryHash(db))
+	}
+	if c.Conf.EntityCache {
+		if entityKey := getEntityKey(db); entityKey != "" {
+			return c.keys().QueryKey(c.Conf.InstanceId, tableName, entityKey, "")
+		}
+	}
 
-	if db.Statement.Schema != nil {
-		tableName = db.Statement.Schema.Table
-	} else {
-		tableName = db.Statement.Table
+	group := LIST_KEY
+	if detailKeys := getDetailKeys(db); len(detailKeys) > 0 {
+		group = detailKeys[0]
+	} else if c.Conf.IDListCache && isIDListQuery(db) {
+		group = IDS_KEY
 	}
 
-	primaryKey := getPrimaryKeyFromWhereClause(db)
-	if primaryKey != "" {
-		keys = append(keys, primaryKey)
+	return c.keys().QueryKey(c.Conf.InstanceId, tableName, group, hashQuery(db.Statement.SQL.String(), db.Statement.Vars))
+}
+
+// buildMarkerKeys returns the keys marking the rows of a detail entry besides the one it is keyed under,
+// so evicting any of its rows by primary key also invalidates the entry. Preloaded graphs are marked
+// in the list keys of the tables of their preloads instead.
+func (c *Caches) buildMarkerKeys(db *gorm.DB) []string {
+	if tables, ok := c.preloadGraph(db); ok {
+		hash := c.queryHash(db)
+		tenant := c.tenant(db.Statement.Context)
+		markerKeys := make([]string, 0, len(tables))
+		for _, tableName := range tables {
+			markerKeys = append(markerKeys, c.keys().QueryKey(c.Conf.InstanceId, keyTable(tableName, tenant), LIST_KEY, hash))
+		}
+		return markerKeys
 	}
 
-	callbacks.BuildQuerySQL(db)
-	keys = append(keys, hashKey(fmt.Sprintf("%s-%s", db.Statement.SQL.String(), fmt.Sprintf("%v", db.Statement.Vars))))
+	detailKeys := getDetailKeys(db)
+	if len(detailKeys) < 2 {
+		return nil
+	}
 
-	return GenCacheKey(c.Conf.InstanceId, tableName, strings.Join(keys, "-"))
+	hash := hashQuery(db.Statement.SQL.String(), db.Statement.Vars)
+	markerKeys := make([]string, 0, len(detailKeys)-1)
+	for _, primaryKey := range detailKeys[1:] {
+		markerKeys = append(markerKeys, c.keys().QueryKey(c.Conf.InstanceId, c.statementTable(db), primaryKey, hash))
+	}
+	return markerKeys
+}
+
+func getTableName(db *gorm.DB) string {
+	if db.Statement.Schema != nil {
+		return db.Statement.Schema.Table
+	}
+	return db.Statement.Table
 }
 
 func GenCacheKey(instanceId, tableName, key string) string {
@@ -56,146 +102,295 @@ func GenCachePrefix(instanceId string, tableName string) string {
 	return fmt.Sprintf(CACHE_PATTERN, instanceId, tableName, "")
 }
 
-func getPrimaryKeyFromWhereClause(db *gorm.DB) string {
-	primaryKeys := make([]string, 0)
-	claLimit, ok := db.Statement.Clauses["LIMIT"]
-	if !ok {
-		return LIST_KEY
+// getTableFromKey extracts the table name out of a key built by GenCacheKey
+func getTableFromKey(key string) string {
+	idx := strings.Index(key, ":TABLE_")
+	if idx < 0 {
+		return ""
 	}
 
-	limit, ok := claLimit.Expression.(clause.Limit)
-	if !ok || *limit.Limit != 1 {
-		return LIST_KEY
+	tableName := key[idx+len(":TABLE_"):]
+	if end := strings.Index(tableName, ":"); end >= 0 {
+		tableName = tableName[:end]
 	}
+	return tableName
+}
 
-	claWhere, ok := db.Statement.Clauses["WHERE"]
-	if !ok {
-		return LIST_KEY
+func GenInstancePrefix(instanceId string) string {
+	return fmt.Sprintf(INSTANCE_PATTERN, instanceId)
+}
+
+// tombstoneKey builds the key of the tombstone of the table, qualified with its tenant if any
+// (see keyTable), or of the whole instance if tableName is empty, see Config.StaleWriteProtection
+func (c *Caches) tombstoneKey(tableName string) string {
+	if tableName == "" {
+		return tombstonePrefix + c.keys().InstancePrefix(c.Conf.InstanceId)
 	}
+	return tombstonePrefix + c.keys().TablePrefix(c.Conf.InstanceId, tableName)
+}
+
+// getDetailKeys returns the distinct primary keys of the rows the statement is restricted to,
+// or nil if the statement is to be keyed as a list
+func getDetailKeys(db *gorm.DB) []string {
+	primaryKeys := getPrimaryKeysFromWhereClause(db)
 
-	dbName := ""
-	for _, field := range db.Statement.Schema.Fields {
-		if field.PrimaryKey {
-			dbName = field.DBName
-			break
+	seen := make(map[string]struct{}, len(primaryKeys))
+	detailKeys := make([]string, 0, len(primaryKeys))
+	for _, primaryKey := range primaryKeys {
+		if _, ok := seen[primaryKey]; ok {
+			continue
 		}
+		seen[primaryKey] = struct{}{}
+		detailKeys = append(detailKeys, primaryKey)
 	}
-	if len(dbName) == 0 {
-		return LIST_KEY
+
+	if len(detailKeys) == 0 || len(detailKeys) > MAX_DETAIL_KEYS {
+		return nil
+	}
+	return detailKeys
+}
+
+// getPrimaryKeysFromWhereClause extracts the primary keys of the rows the where clause restricts the statement to,
+// composite keys have their values joined by COMPOSITE_KEY_SEPARATOR in the order of Schema.PrimaryFields.
+// It returns nil unless every primary field is restricted, or if the rows are more than MAX_DETAIL_KEYS.
+func getPrimaryKeysFromWhereClause(db *gorm.DB) []string {
+	primaryKeys, _ := getWhereConditions(db)
+	return primaryKeys
+}
+
+// getWhereConditions extracts the primary keys as getPrimaryKeysFromWhereClause does, along with the conditions
+// of the where clause not restricting primary fields only
+func getWhereConditions(db *gorm.DB) ([]string, []clause.Expression) {
+	if db.Statement.Schema == nil || len(db.Statement.Schema.PrimaryFields) == 0 {
+		return nil, nil
+	}
+
+	claWhere, ok := db.Statement.Clauses["WHERE"]
+	if !ok {
+		return nil, nil
 	}
 
 	where, ok := claWhere.Expression.(clause.Where)
 	if !ok {
-		return LIST_KEY
+		return nil, nil
+	}
+
+	primaryFields := db.Statement.Schema.PrimaryFields
+	prioritizedField := db.Statement.Schema.PrioritizedPrimaryField
+	values := make(map[string][]string, len(primaryFields))
+
+	// primaryFieldOf returns the primary field matching the column, if any
+	primaryFieldOf := func(colName string) string {
+		if colName == clause.PrimaryKey && prioritizedField != nil {
+			return prioritizedField.DBName
+		}
+		for _, field := range primaryFields {
+			if field.DBName == colName {
+				return field.DBName
+			}
+		}
+		return ""
+	}
+
+	exprs, ok := flattenAndConditions(where.Exprs)
+	if !ok {
+		return nil, where.Exprs
 	}
 
-	for _, expr := range where.Exprs {
+	others := make([]clause.Expression, 0)
+	for _, expr := range exprs {
+		// vars not binding scalars, e.g. subqueries, leave the condition unparsed
 		eqExpr, ok := expr.(clause.Eq)
 		if ok {
-			if eqExpr.Column == clause.PrimaryKey || getColNameFromColumn(eqExpr.Column) == dbName {
-				primaryKeys = append(primaryKeys, fmt.Sprintf("%v", eqExpr.Value))
-				continue
+			if dbName := primaryFieldOf(getColNameFromColumn(eqExpr.Column)); dbName != "" {
+				if value, ok := scalarKeyValue(eqExpr.Value); ok {
+					values[dbName] = append(values[dbName], value)
+					continue
+				}
 			}
 		}
 
 		inExpr, ok := expr.(clause.IN)
 		if ok {
-			if getColNameFromColumn(inExpr.Column) == clause.PrimaryKey || getColNameFromColumn(inExpr.Column) == dbName {
-				for _, val := range inExpr.Values {
-					primaryKeys = append(primaryKeys, fmt.Sprintf("%v", val))
+			if dbName := primaryFieldOf(getColNameFromColumn(inExpr.Column)); dbName != "" {
+				if inValues, ok := scalarKeyValues(inExpr.Values); ok {
+					values[dbName] = append(values[dbName], inValues...)
+					continue
 				}
-				continue
 			}
 		}
 
 		exprStruct, ok := expr.(clause.Expr)
 		if ok {
-			ttype := getExprType(exprStruct)
-			if ttype == "in" || ttype == "eq" {
-				colName := getColNameFromExpr(exprStruct, ttype)
-				splitedColName := strings.Split(colName, ".")
-				fieldName := splitedColName[len(splitedColName)-1]
-				if fieldName == dbName {
-					pKeys := getPrimaryKeysFromExpr(exprStruct, ttype)
-					primaryKeys = append(primaryKeys, pKeys...)
+			conditions, parsed := parseExprConditions(exprStruct)
+			for _, condition := range conditions {
+				if dbName := primaryFieldOf(condition.Column); dbName != "" {
+					values[dbName] = append(values[dbName], condition.Values...)
+				} else {
+					parsed = false
 				}
 			}
+			if parsed {
+				continue
+			}
 		}
-	}
 
-	if len(primaryKeys) == 0 {
-		return LIST_KEY
+		others = append(others, expr)
 	}
-	return strings.Join(primaryKeys, "_")
-}
 
-func getColNameFromColumn(col interface{}) string {
-	switch v := col.(type) {
-	case string:
-		return v
-	case clause.Column:
-		return v.Name
-	default:
-		return ""
+	// combine the values of every primary field into the keys of the rows, up to MAX_DETAIL_KEYS of them
+	primaryKeys := []string{""}
+	for i, field := range primaryFields {
+		fieldValues := values[field.DBName]
+		if len(fieldValues) == 0 || len(primaryKeys)*len(fieldValues) > MAX_DETAIL_KEYS {
+			return nil, others
+		}
+
+		combined := make([]string, 0, len(primaryKeys)*len(fieldValues))
+		for _, primaryKey := range primaryKeys {
+			for _, value := range fieldValues {
+				if i > 0 {
+					value = primaryKey + COMPOSITE_KEY_SEPARATOR + value
+				}
+				combined = append(combined, value)
+			}
+		}
+		primaryKeys = combined
 	}
+	return primaryKeys, others
 }
 
-func getColNameFromExpr(expr clause.Expr, ttype string) string {
-	sql := strings.Replace(strings.ToLower(expr.SQL), " ", "", -1)
-	if ttype == "in" {
-		fields := strings.Split(sql, "in")
-		return fields[0]
-	} else if ttype == "eq" {
-		fields := strings.Split(sql, "=")
-		return fields[0]
+// flattenAndConditions lists the expressions ANDed together, as built by struct and map conditions.
+// It reports false if any is ORed, as none of them restricts the statement on its own then.
+func flattenAndConditions(exprs []clause.Expression) ([]clause.Expression, bool) {
+	flattened := make([]clause.Expression, 0, len(exprs))
+	for _, expr := range exprs {
+		switch v := expr.(type) {
+		case clause.OrConditions:
+			return nil, false
+		case clause.AndConditions:
+			nested, ok := flattenAndConditions(v.Exprs)
+			if !ok {
+				return nil, false
+			}
+			flattened = append(flattened, nested...)
+		default:
+			flattened = append(flattened, expr)
+		}
 	}
-	return ""
+	return flattened, true
 }
 
-func getPrimaryKeysFromExpr(expr clause.Expr, ttype string) []string {
-	sql := strings.Replace(strings.ToLower(expr.SQL), " ", "", -1)
+// getPrimaryKeysFromDest collects the primary keys of the rows held by the statement, e.g. created records,
+// composite keys being joined as in getPrimaryKeysFromWhereClause
+func getPrimaryKeysFromDest(db *gorm.DB) []string {
+	if db.Statement.Schema == nil || len(db.Statement.Schema.PrimaryFields) == 0 {
+		return nil
+	}
 
 	primaryKeys := make([]string, 0)
+	collect := func(rv reflect.Value) {
+		if primaryKey, ok := getPrimaryKeyOfRow(db, rv); ok {
+			primaryKeys = append(primaryKeys, primaryKey)
+		}
+	}
 
-	if ttype == "in" {
-		fields := strings.Split(sql, "in")
-		if len(fields) == 2 {
-			if fields[1][0] == '(' && fields[1][len(fields[1])-1] == ')' {
-				idStr := fields[1][1 : len(fields[1])-1]
-				ids := strings.Split(idStr, ",")
-				for _, id := range ids {
-					if id == "?" {
-						for _, vvar := range expr.Vars {
-							keys := extractStringsFromVar(vvar)
-							primaryKeys = append(primaryKeys, keys...)
-						}
-						break
-					}
-					number, err := strconv.ParseInt(id, 10, 64)
-					if err == nil {
-						primaryKeys = append(primaryKeys, strconv.FormatInt(number, 10))
-					}
-				}
-			} else if fields[1] == "(?)" {
-				for _, val := range expr.Vars {
-					primaryKeys = append(primaryKeys, fmt.Sprintf("%v", val))
-				}
+	switch rv := reflect.Indirect(db.Statement.ReflectValue); rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if elem := reflect.Indirect(rv.Index(i)); elem.Kind() == reflect.Struct {
+				collect(elem)
 			}
 		}
-	} else if ttype == "eq" {
-		fields := strings.Split(sql, "=")
-		if len(fields) == 2 {
-			_, err := strconv.ParseInt(fields[1], 10, 64)
-			if err == nil {
-				primaryKeys = append(primaryKeys, fields[1])
-			} else if fields[1] == "?" {
-				for _, val := range expr.Vars {
-					primaryKeys = append(primaryKeys, fmt.Sprintf("%v", val))
-				}
+	case reflect.Struct:
+		collect(rv)
+	}
+	return primaryKeys
+}
+
+// getPrimaryKeyOfRow returns the primary key of the row held by the struct value, reporting false
+// if all its primary fields are zero
+func getPrimaryKeyOfRow(db *gorm.DB, rv reflect.Value) (string, bool) {
+	values := make([]string, 0, len(db.Statement.Schema.PrimaryFields))
+	allZero := true
+	for _, field := range db.Statement.Schema.PrimaryFields {
+		val, isZero := field.ValueOf(db.Statement.Context, rv)
+		allZero = allZero && isZero
+		values = append(values, keyValue(val))
+	}
+	return strings.Join(values, COMPOSITE_KEY_SEPARATOR), !allZero
+}
+
+// keyValue formats a primary key value as it appears in the keys, driver.Valuer as the value they bind,
+// e.g. sql.NullInt64{Int64: 5, Valid: true} as 5
+func keyValue(v interface{}) string {
+	if valuer, ok := v.(driver.Valuer); ok {
+		if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || !rv.IsNil() {
+			if value, err := valuer.Value(); err == nil {
+				v = value
 			}
 		}
 	}
-	return primaryKeys
+	return fmt.Sprintf("%v", v)
+}
+
+// scalarKeyValue formats the var as keyValue does, reporting false unless it binds a single scalar value:
+// subqueries, expressions and structs do not name a row, neither do NULL values
+func scalarKeyValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil, *gorm.DB, clause.Expression:
+		return "", false
+	case driver.Valuer:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return "", false
+		}
+		if value, err := v.Value(); err != nil || value == nil {
+			return "", false
+		}
+		return keyValue(v), true
+	case fmt.Stringer, []byte:
+		// e.g. UUIDs, which are arrays
+		return keyValue(v), true
+	}
+
+	switch rv := reflect.Indirect(reflect.ValueOf(v)); rv.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		return fmt.Sprintf("%v", rv.Interface()), true
+	}
+	return "", false
+}
+
+// scalarKeyValues formats the var as scalarKeyValue does, expanding slices of scalars
+func scalarKeyValues(v interface{}) ([]string, bool) {
+	if value, ok := scalarKeyValue(v); ok {
+		return []string{value}, true
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	values := make([]string, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		value, ok := scalarKeyValue(rv.Index(i).Interface())
+		if !ok {
+			return nil, false
+		}
+		values = append(values, value)
+	}
+	return values, true
+}
+
+func getColNameFromColumn(col interface{}) string {
+	switch v := col.(type) {
+	case string:
+		return v
+	case clause.Column:
+		return v.Name
+	default:
+		return ""
+	}
 }
 
 func extractStringsFromVar(v interface{}) []string {
@@ -216,31 +411,3 @@ func extractStringsFromVar(v interface{}) []string {
 	}
 	return nil
 }
-
-func getExprType(expr clause.Expr) string {
-	// delete spaces
-	sql := strings.Replace(strings.ToLower(expr.SQL), " ", "", -1)
-
-	// see if sql has more than one clause
-	hasConnector := strings.Contains(sql, "and") || strings.Contains(sql, "or")
-
-	if strings.Contains(sql, "=") && !hasConnector {
-		// possibly "id=?" or "id=123"
-		fields := strings.Split(sql, "=")
-		if len(fields) == 2 {
-			_, isNumberErr := strconv.ParseInt(fields[1], 10, 64)
-			if fields[1] == "?" || isNumberErr == nil {
-				return "eq"
-			}
-		}
-	} else if strings.Contains(sql, "in") && !hasConnector {
-		// possibly "idIN(?)"
-		fields := strings.Split(sql, "in")
-		if len(fields) == 2 {
-			if len(fields[1]) > 1 && fields[1][0] == '(' && fields[1][len(fields[1])-1] == ')' {
-				return "in"
-			}
-		}
-	}
-	return "other"
-}
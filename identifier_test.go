@@ -1,11 +1,19 @@
 package caches
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
 )
 
+type mockUser struct {
+	ID   uint
+	Name string
+}
+
 func Test_buildIdentifier(t *testing.T) {
 	db := &gorm.DB{}
 	caches := &Caches{
@@ -18,8 +26,206 @@ func Test_buildIdentifier(t *testing.T) {
 	db.Statement.Vars = append(db.Statement.Vars, "test", 123, 12.3, true, false, []string{"test", "me"})
 
 	actual := caches.buildIdentifier(db)
-	expected := "INSTANCE_123:TABLE_:LIST-" + hashKey(`TEST-SQL-[test 123 12.3 true false [test me]]`)
+	expected := "INSTANCE_123:TABLE_:LIST-" + hashQuery("TEST-SQL", db.Statement.Vars)
 	if actual != expected {
 		t.Errorf("buildIdentifier expected to return `%s` but got `%s`", expected, actual)
 	}
 }
+
+func Test_getPrimaryKeysFromDest(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err := db.Statement.Parse(&mockUser{}); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	db.Statement.ReflectValue = reflect.ValueOf([]mockUser{{ID: 1}, {ID: 2}, {Name: "unsaved"}})
+	if actual := getPrimaryKeysFromDest(db); !reflect.DeepEqual(actual, []string{"1", "2"}) {
+		t.Errorf("getPrimaryKeysFromDest expected to return [1 2] but got %v", actual)
+	}
+
+	db.Statement.ReflectValue = reflect.ValueOf(&mockUser{ID: 3})
+	if actual := getPrimaryKeysFromDest(db); !reflect.DeepEqual(actual, []string{"3"}) {
+		t.Errorf("getPrimaryKeysFromDest expected to return [3] but got %v", actual)
+	}
+}
+
+func Test_getTableFromKey(t *testing.T) {
+	for key, expected := range map[string]string{
+		GenCacheKey("123", "users", LIST_KEY):            "users",
+		GenCachePrefix("123", "user_roles"):              "user_roles",
+		tombstonePrefix + GenCachePrefix("123", "users"): "users",
+		"unrelated-key": "",
+	} {
+		if actual := getTableFromKey(key); actual != expected {
+			t.Errorf("getTableFromKey(%q) expected to return `%s` but got `%s`", key, expected, actual)
+		}
+	}
+}
+
+type mockMembership struct {
+	TenantID uint `gorm:"primaryKey;autoIncrement:false"`
+	UserID   uint `gorm:"primaryKey;autoIncrement:false"`
+	Role     string
+}
+
+func Test_getPrimaryKeysFromWhereClause(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	newStatement := func(model interface{}, scope func(*gorm.DB) *gorm.DB) *gorm.DB {
+		tx := scope(db.Session(&gorm.Session{NewDB: true}).Model(model))
+		if err := tx.Statement.Parse(model); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		return tx
+	}
+
+	for name, tc := range map[string]struct {
+		db       *gorm.DB
+		expected []string
+	}{
+		"single key": {
+			db: newStatement(&mockUser{}, func(tx *gorm.DB) *gorm.DB {
+				return tx.Where("id = ?", 5)
+			}),
+			expected: []string{"5"},
+		},
+		"composite key": {
+			db: newStatement(&mockMembership{}, func(tx *gorm.DB) *gorm.DB {
+				return tx.Where(&mockMembership{TenantID: 42, UserID: 7})
+			}),
+			expected: []string{"42,7"},
+		},
+		"composite key with several values": {
+			db: newStatement(&mockMembership{}, func(tx *gorm.DB) *gorm.DB {
+				return tx.Where("tenant_id = ?", 42).Where("user_id IN (?)", []int{7, 8})
+			}),
+			expected: []string{"42,7", "42,8"},
+		},
+		"composite key with too many values": {
+			db: newStatement(&mockMembership{}, func(tx *gorm.DB) *gorm.DB {
+				values := make([]int, 20)
+				for i := range values {
+					values[i] = i
+				}
+				return tx.Where("tenant_id IN ?", values).Where("user_id IN ?", values)
+			}),
+			expected: nil,
+		},
+		"partial composite key": {
+			db: newStatement(&mockMembership{}, func(tx *gorm.DB) *gorm.DB {
+				return tx.Where("tenant_id = ?", 42)
+			}),
+			expected: nil,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if actual := getPrimaryKeysFromWhereClause(tc.db); !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("getPrimaryKeysFromWhereClause expected to return %v but got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_getPrimaryKeysFromDest_Composite(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err := db.Statement.Parse(&mockMembership{}); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	db.Statement.ReflectValue = reflect.ValueOf([]mockMembership{{TenantID: 42, UserID: 7}, {TenantID: 42, UserID: 8}})
+	if actual := getPrimaryKeysFromDest(db); !reflect.DeepEqual(actual, []string{"42,7", "42,8"}) {
+		t.Errorf("getPrimaryKeysFromDest expected to return [42,7 42,8] but got %v", actual)
+	}
+}
+
+func Test_getPrimaryKeysFromWhereClause_Or(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	tx := db.Model(&mockUser{}).Where("id = ?", 5).Or("name = ?", "anonymous")
+	if err := tx.Statement.Parse(&mockUser{}); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	if actual := getPrimaryKeysFromWhereClause(tx); actual != nil {
+		t.Errorf("getPrimaryKeysFromWhereClause expected not to return keys of ORed conditions, got %v", actual)
+	}
+}
+
+func Test_getDetailKeys(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	newStatement := func(scope func(*gorm.DB) *gorm.DB) *gorm.DB {
+		tx := scope(db.Session(&gorm.Session{NewDB: true}).Model(&mockUser{}))
+		if err := tx.Statement.Parse(&mockUser{}); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		return tx
+	}
+
+	ids := make([]int, MAX_DETAIL_KEYS+1)
+	for i := range ids {
+		ids[i] = i + 1
+	}
+
+	for name, tc := range map[string]struct {
+		db       *gorm.DB
+		expected []string
+	}{
+		"without limit": {
+			db: newStatement(func(tx *gorm.DB) *gorm.DB {
+				return tx.Where("id IN ?", []int{1, 2, 3})
+			}),
+			expected: []string{"1", "2", "3"},
+		},
+		"duplicated keys": {
+			db: newStatement(func(tx *gorm.DB) *gorm.DB {
+				return tx.Where("id IN ?", []int{1, 2, 1})
+			}),
+			expected: []string{"1", "2"},
+		},
+		"too many keys": {
+			db: newStatement(func(tx *gorm.DB) *gorm.DB {
+				return tx.Where("id IN ?", ids)
+			}),
+			expected: nil,
+		},
+		"not scoped by primary key": {
+			db: newStatement(func(tx *gorm.DB) *gorm.DB {
+				return tx.Where("name = ?", "john")
+			}),
+			expected: nil,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if actual := getDetailKeys(tc.db); !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("getDetailKeys expected to return %v but got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_buildMarkerKeys(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	caches := &Caches{
+		Conf: &Config{
+			InstanceId: "123",
+		},
+	}
+
+	tx := db.Model(&mockUser{}).Where("id IN ?", []int{1, 2, 3})
+	if err := tx.Statement.Parse(&mockUser{}); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	identifier := caches.buildIdentifier(tx)
+	prefix := GenCacheKey("123", "mock_users", "1-")
+	if !strings.HasPrefix(identifier, prefix) {
+		t.Fatalf("buildIdentifier expected to key the query under its first row `%s`, got `%s`", prefix, identifier)
+	}
+
+	hash := strings.TrimPrefix(identifier, prefix)
+	expected := []string{
+		GenCacheKey("123", "mock_users", "2-"+hash),
+		GenCacheKey("123", "mock_users", "3-"+hash),
+	}
+	if actual := caches.buildMarkerKeys(tx); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("buildMarkerKeys expected to return %v but got %v", expected, actual)
+	}
+}
@@ -0,0 +1,199 @@
+package caches
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// isIDListQuery reports whether the statement loads whole rows of a model with a single primary field
+// into a slice of the model, see Config.IDListCache
+func isIDListQuery(db *gorm.DB) bool {
+	stmt := db.Statement
+	if !loadsWholeRows(db) || len(stmt.Schema.PrimaryFields) != 1 {
+		return false
+	}
+
+	destType := reflect.TypeOf(stmt.Dest)
+	return destType != nil && destType.Kind() == reflect.Ptr && destType.Elem().Kind() == reflect.Slice &&
+		destType.Elem().Elem() == stmt.Schema.ModelType
+}
+
+func (c *Caches) isIDListIdentifier(db *gorm.DB, identifier string) bool {
+	return strings.HasPrefix(identifier, c.keys().ListPrefix(c.Conf.InstanceId, c.statementTable(db), IDS_KEY))
+}
+
+// hydrate binds the rows of the ID-list entry to Statement.Dest from their row entries, fetching the missing
+// ones from the database by primary key. It reports false if any of the rows is gone.
+func (c *Caches) hydrate(db *gorm.DB, query Query) bool {
+	var (
+		stmt      = db.Statement
+		tableName = c.statementTable(db)
+		rows      = reflect.MakeSlice(reflect.SliceOf(stmt.Schema.ModelType), len(query.PrimaryKeys), len(query.PrimaryKeys))
+		missing   = make(map[string]struct{})
+	)
+
+	rowKeys := make([]string, 0, len(query.PrimaryKeys))
+	for _, primaryKey := range query.PrimaryKeys {
+		rowKeys = append(rowKeys, c.keys().QueryKey(c.Conf.InstanceId, tableName, primaryKey, ""))
+	}
+	vals, err := getMulti(c.cacher(), rowKeys)
+	if err != nil {
+		// fetched from the database as if missing
+		vals = make([][]byte, len(rowKeys))
+	}
+
+	for i, primaryKey := range query.PrimaryKeys {
+		if !c.loadRow(vals[i], rows.Index(i).Addr().Interface()) {
+			missing[primaryKey] = struct{}{}
+		}
+	}
+
+	if len(missing) > 0 {
+		start := c.startQuery(c.tenant(stmt.Context), getTableName(db))
+		primaryKeys := make([]string, 0, len(missing))
+		for primaryKey := range missing {
+			primaryKeys = append(primaryKeys, primaryKey)
+		}
+
+		fetched, err := c.fetchRows(db, primaryKeys)
+		if err != nil {
+			db.Logger.Error(stmt.Context, "[hydrate - Query] %s", err)
+			return false
+		}
+
+		ttl := c.cacheTTL()
+		entries := make([]*cacheEntry, 0, len(fetched))
+		for i, primaryKey := range query.PrimaryKeys {
+			if _, ok := missing[primaryKey]; !ok {
+				continue
+			}
+			row, ok := fetched[primaryKey]
+			if !ok {
+				return false
+			}
+			rows.Index(i).Set(row)
+
+			entry, err := c.rowEntry(db, primaryKey, row.Interface(), ttl, start)
+			if err != nil {
+				db.Logger.Error(stmt.Context, "[hydrate - Serialize] %s", err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+
+		logger, ctx := db.Logger, stmt.Context
+		c.async(func() {
+			c.setCache(logger, ctx, entries...)
+		})
+	}
+
+	reflect.ValueOf(stmt.Dest).Elem().Set(rows)
+	return true
+}
+
+// loadRow binds the row entry to the row, reporting false if there is none
+func (c *Caches) loadRow(res []byte, row interface{}) bool {
+	if res == nil {
+		return false
+	}
+
+	var query Query
+	if err := c.Conf.Serializer.Deserialize(res, &query); err != nil || query.NotFound || query.Dest == nil {
+		return false
+	}
+
+	serializedDest, err := c.Conf.Serializer.Serialize(query.Dest)
+	if err != nil {
+		return false
+	}
+	return c.Conf.Serializer.Deserialize(serializedDest, row) == nil
+}
+
+// fetchRows queries the rows with the given primary keys, bypassing the cache
+func (c *Caches) fetchRows(db *gorm.DB, primaryKeys []string) (map[string]reflect.Value, error) {
+	var (
+		stmt   = db.Statement
+		field  = stmt.Schema.PrimaryFields[0]
+		values = make([]interface{}, 0, len(primaryKeys))
+	)
+
+	for _, primaryKey := range primaryKeys {
+		rv := reflect.New(stmt.Schema.ModelType).Elem()
+		if err := field.Set(stmt.Context, rv, primaryKey); err != nil {
+			return nil, err
+		}
+		value, _ := field.ValueOf(stmt.Context, rv)
+		values = append(values, value)
+	}
+
+	rows := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+	tx := db.Session(&gorm.Session{NewDB: true, Context: context.WithValue(stmt.Context, c.Name(), false)})
+	if err := tx.Where(clause.IN{Column: clause.PrimaryColumn, Values: values}).Find(rows.Interface()).Error; err != nil {
+		return nil, err
+	}
+
+	fetched := make(map[string]reflect.Value, rows.Elem().Len())
+	for i := 0; i < rows.Elem().Len(); i++ {
+		if primaryKey, ok := getPrimaryKeyOfRow(db, rows.Elem().Index(i)); ok {
+			fetched[primaryKey] = rows.Elem().Index(i)
+		}
+	}
+	return fetched, nil
+}
+
+// snapshotRows builds the row entries of the rows held by the statement, along with their primary keys in order.
+// They share their TTL, so they can be stored at once.
+func (c *Caches) snapshotRows(db *gorm.DB, start *queryStart) ([]*cacheEntry, []string, error) {
+	ttl := c.cacheTTL()
+	rows := reflect.Indirect(reflect.ValueOf(db.Statement.Dest))
+	entries := make([]*cacheEntry, 0, rows.Len())
+	primaryKeys := make([]string, 0, rows.Len())
+
+	for i := 0; i < rows.Len(); i++ {
+		primaryKey, ok := getPrimaryKeyOfRow(db, rows.Index(i))
+		if !ok {
+			return nil, nil, fmt.Errorf("row %d of %s has no primary key", i, getTableName(db))
+		}
+
+		entry, err := c.rowEntry(db, primaryKey, rows.Index(i).Interface(), ttl, start)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, entry)
+		primaryKeys = append(primaryKeys, primaryKey)
+	}
+	return entries, primaryKeys, nil
+}
+
+// rowEntry builds the entry of the row, as stored by single-row lookups (see Config.EntityCache)
+func (c *Caches) rowEntry(db *gorm.DB, primaryKey string, row interface{}, ttl time.Duration, start *queryStart) (*cacheEntry, error) {
+	query := Query{
+		Dest:         row,
+		RowsAffected: 1,
+	}
+	if ttl > 0 {
+		query.ExpiresAt = time.Now().Add(ttl)
+	}
+
+	cachedData, err := c.Conf.Serializer.Serialize(query)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{
+		identifier: c.keys().QueryKey(c.Conf.InstanceId, c.statementTable(db), primaryKey, ""),
+		data:       cachedData,
+		ttl:        ttl,
+		start:      start,
+	}
+	if _, ok := c.cacher().(TaggedCacher); ok {
+		entry.tags = c.tagsOf(db, []string{primaryKey})
+	}
+	return entry, nil
+}
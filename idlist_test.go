@@ -0,0 +1,144 @@
+package caches
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestCaches_IDListCache(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	cacher := &cacherCountingMock{MemoryCacher: NewMemoryCacher(0)}
+	caches := &Caches{
+		Conf: &Config{
+			Cacher:           cacher,
+			Serializer:       JSONSerializer{},
+			SyncInvalidation: true,
+			EntityCache:      true,
+			IDListCache:      true,
+		},
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	var (
+		names   = map[uint]string{1: "a", 2: "b", 3: "c"}
+		lists   int
+		fetched [][]string
+	)
+	caches.queryCb = func(tx *gorm.DB) {
+		primaryKeys := getPrimaryKeysFromWhereClause(tx)
+		if len(primaryKeys) == 0 {
+			lists++
+			for id := uint(len(names)); id > 0; id-- {
+				primaryKeys = append(primaryKeys, strconv.Itoa(int(id)))
+			}
+		} else {
+			fetched = append(fetched, primaryKeys)
+		}
+
+		for _, primaryKey := range primaryKeys {
+			id, _ := strconv.Atoi(primaryKey)
+			user := mockUser{ID: uint(id), Name: names[uint(id)]}
+			switch dest := tx.Statement.Dest.(type) {
+			case *[]mockUser:
+				*dest = append(*dest, user)
+			case *mockUser:
+				*dest = user
+			}
+			tx.Statement.RowsAffected++
+		}
+	}
+	list := func() []mockUser {
+		var users []mockUser
+		if err := db.Where("name <> ?", "z").Order("id desc").Find(&users).Error; err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if err := caches.Flush(context.Background()); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		return users
+	}
+
+	expected := []mockUser{{ID: 3, Name: "c"}, {ID: 2, Name: "b"}, {ID: 1, Name: "a"}}
+	if users := list(); !reflect.DeepEqual(users, expected) {
+		t.Fatalf("expected the query to return %v, got %v", expected, users)
+	}
+	atomic.StoreInt32(&cacher.gets, 0)
+	if users := list(); !reflect.DeepEqual(users, expected) || lists != 1 {
+		t.Fatalf("expected the list to be served from cache as %v, got %v after %d queries", expected, users, lists)
+	}
+	if gets, multiGets := atomic.LoadInt32(&cacher.gets), atomic.LoadInt32(&cacher.multiGets); gets != 1 || multiGets != 1 {
+		t.Errorf("expected the rows to be read at once, got %d reads and %d batch reads", gets, multiGets)
+	}
+
+	// updating a row only evicts its entry, fetched again by primary key
+	names[2] = "b2"
+	db.Model(&mockUser{ID: 2}).Update("name", "b2")
+
+	expected[1].Name = "b2"
+	if users := list(); !reflect.DeepEqual(users, expected) {
+		t.Errorf("expected the list to be hydrated as %v, got %v", expected, users)
+	}
+	if lists != 1 || !reflect.DeepEqual(fetched, [][]string{{"2"}}) {
+		t.Errorf("expected only the updated row to be fetched, got %d list queries and fetches %v", lists, fetched)
+	}
+
+	// the fetched row is shared with primary key lookups
+	var user mockUser
+	db.First(&user, 2)
+	if user.Name != "b2" || len(fetched) != 1 {
+		t.Errorf("expected the lookup to be served from the row entry, got %+v after fetches %v", user, fetched)
+	}
+
+	// updating rows out of their primary keys evicts the lists along with the row entries
+	names[3] = "c2"
+	db.Model(&mockUser{}).Where("name = ?", "c").Updates(map[string]interface{}{"name": "c2"})
+
+	expected[0].Name = "c2"
+	if users := list(); !reflect.DeepEqual(users, expected) || lists != 2 {
+		t.Errorf("expected the list to be queried again as %v, got %v after %d queries", expected, users, lists)
+	}
+
+	// creating a row evicts the ID lists
+	names[4] = "d"
+	db.Create(&mockUser{ID: 4, Name: "d"})
+
+	if users := list(); len(users) != 4 || lists != 3 {
+		t.Errorf("expected the list to be queried again after a creation, got %v after %d queries", users, lists)
+	}
+}
+
+func Test_isIDListQuery(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+
+	for name, tc := range map[string]struct {
+		model    interface{}
+		dest     interface{}
+		expected bool
+	}{
+		"slice of the model":         {model: &mockUser{}, dest: &[]mockUser{}, expected: true},
+		"slice of another type":      {model: &mockUser{}, dest: &[]mockDest{}},
+		"single row":                 {model: &mockUser{}, dest: &mockUser{}},
+		"composite primary key":      {model: &mockMembership{}, dest: &[]mockMembership{}},
+		"slice of pointers to model": {model: &mockUser{}, dest: &[]*mockUser{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tx := db.Session(&gorm.Session{NewDB: true}).Model(tc.model)
+			if err := tx.Statement.Parse(tc.model); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+			tx.Statement.Dest = tc.dest
+
+			if actual := isIDListQuery(tx); actual != tc.expected {
+				t.Errorf("isIDListQuery expected to return %t but got %t", tc.expected, actual)
+			}
+		})
+	}
+}
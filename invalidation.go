@@ -0,0 +1,86 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var ErrNotInitialized = errors.New("gorm:caches: plugin is not initialized")
+
+// InvalidateTable evicts every entry of the model's table, the model being a struct, a pointer to one
+// or a table name. Useful after writes bypassing gorm, e.g. a bulk load or another service.
+// With a Config.TenantResolver, only the entries of the tenant of the context are evicted.
+func (c *Caches) InvalidateTable(ctx context.Context, model interface{}) error {
+	tableName, err := c.resolveTable(model)
+	if err != nil {
+		return err
+	}
+
+	return joinErrors(c.invalidate(ctx, InvalidationEvent{
+		InstanceId: c.Conf.InstanceId,
+		Origin:     c.origin(),
+		Table:      tableName,
+		Tenant:     c.tenant(ctx),
+		Prefixes:   []string{""},
+	}, true))
+}
+
+// InvalidateKeys evicts the entries of the rows with the given primary keys, along with the list and ID-list
+// entries of their table, as a deletion of these rows would. Composite keys are given as slices of their values,
+// in the order of the primary fields.
+func (c *Caches) InvalidateKeys(ctx context.Context, model interface{}, primaryKeys ...interface{}) error {
+	tableName, err := c.resolveTable(model)
+	if err != nil {
+		return err
+	}
+
+	// ID lists go too, as they may hold rows deleted outside of gorm
+	prefixes := []string{LIST_KEY, IDS_KEY}
+	for _, primaryKey := range primaryKeys {
+		if values := extractStringsFromVar(primaryKey); len(values) > 1 {
+			prefixes = append(prefixes, strings.Join(values, COMPOSITE_KEY_SEPARATOR))
+			continue
+		}
+		prefixes = append(prefixes, keyValue(primaryKey))
+	}
+
+	return joinErrors(c.invalidate(ctx, InvalidationEvent{
+		InstanceId: c.Conf.InstanceId,
+		Origin:     c.origin(),
+		Table:      tableName,
+		Tenant:     c.tenant(ctx),
+		Prefixes:   prefixes,
+	}, true))
+}
+
+// InvalidateAll evicts every entry of the instance
+func (c *Caches) InvalidateAll(ctx context.Context) error {
+	if c.cacher() == nil {
+		return nil
+	}
+
+	return joinErrors(c.invalidate(ctx, InvalidationEvent{
+		InstanceId: c.Conf.InstanceId,
+		Origin:     c.origin(),
+		All:        true,
+	}, true))
+}
+
+func (c *Caches) resolveTable(model interface{}) (string, error) {
+	if tableName, ok := model.(string); ok {
+		return tableName, nil
+	}
+
+	if c.db == nil {
+		return "", ErrNotInitialized
+	}
+
+	stmt := &gorm.Statement{DB: c.db}
+	if err := stmt.Parse(model); err != nil {
+		return "", err
+	}
+	return stmt.Schema.Table, nil
+}
@@ -0,0 +1,115 @@
+package caches
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func newInvalidationCaches(t *testing.T) (*Caches, *MemoryCacher) {
+	cacher := NewMemoryCacher(0)
+	caches := &Caches{Conf: &Config{
+		InstanceId: "123",
+		Cacher:     cacher,
+		Serializer: JSONSerializer{},
+	}}
+
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+
+	for _, key := range []string{
+		GenCacheKey("123", "mock_users", LIST_KEY+"-a"),
+		GenCacheKey("123", "mock_users", IDS_KEY+"-f"),
+		GenCacheKey("123", "mock_users", "1-b"),
+		GenCacheKey("123", "mock_users", "2-c"),
+		GenCacheKey("123", "roles", LIST_KEY+"-d"),
+	} {
+		_ = cacher.Set(key, []byte("val"), time.Minute)
+	}
+	return caches, cacher
+}
+
+func assertCached(t *testing.T, cacher *MemoryCacher, expected map[string]bool) {
+	t.Helper()
+	for key, cached := range expected {
+		if val, _ := cacher.Get(key); (val != nil) != cached {
+			t.Errorf("expected `%s` to be cached: %t", key, cached)
+		}
+	}
+}
+
+func TestCaches_InvalidateTable(t *testing.T) {
+	caches, cacher := newInvalidationCaches(t)
+	defer caches.Close()
+
+	if err := caches.InvalidateTable(context.Background(), &mockUser{}); err != nil {
+		t.Fatalf("InvalidateTable returned an unexpected error %v", err)
+	}
+
+	assertCached(t, cacher, map[string]bool{
+		GenCacheKey("123", "mock_users", LIST_KEY+"-a"): false,
+		GenCacheKey("123", "mock_users", "1-b"):         false,
+		GenCacheKey("123", "roles", LIST_KEY+"-d"):      true,
+	})
+}
+
+func TestCaches_InvalidateKeys(t *testing.T) {
+	caches, cacher := newInvalidationCaches(t)
+	defer caches.Close()
+
+	if err := caches.InvalidateKeys(context.Background(), "mock_users", 1); err != nil {
+		t.Fatalf("InvalidateKeys returned an unexpected error %v", err)
+	}
+
+	assertCached(t, cacher, map[string]bool{
+		GenCacheKey("123", "mock_users", LIST_KEY+"-a"): false,
+		GenCacheKey("123", "mock_users", IDS_KEY+"-f"):  false,
+		GenCacheKey("123", "mock_users", "1-b"):         false,
+		GenCacheKey("123", "mock_users", "2-c"):         true,
+		GenCacheKey("123", "roles", LIST_KEY+"-d"):      true,
+	})
+}
+
+func TestCaches_InvalidateAll(t *testing.T) {
+	caches, cacher := newInvalidationCaches(t)
+	defer caches.Close()
+	_ = cacher.Set(GenCacheKey("456", "roles", LIST_KEY+"-e"), []byte("val"), time.Minute)
+
+	if err := caches.InvalidateAll(context.Background()); err != nil {
+		t.Fatalf("InvalidateAll returned an unexpected error %v", err)
+	}
+
+	assertCached(t, cacher, map[string]bool{
+		GenCacheKey("123", "mock_users", "1-b"):    false,
+		GenCacheKey("123", "roles", LIST_KEY+"-d"): false,
+		GenCacheKey("456", "roles", LIST_KEY+"-e"): true,
+	})
+}
+
+func TestCaches_InvalidateTable_NotInitialized(t *testing.T) {
+	caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher(0)}}
+	if err := caches.InvalidateTable(context.Background(), &mockUser{}); err != ErrNotInitialized {
+		t.Errorf("InvalidateTable expected to return %v, got %v", ErrNotInitialized, err)
+	}
+}
+
+func TestCaches_InvalidateKeys_Composite(t *testing.T) {
+	caches, cacher := newInvalidationCaches(t)
+	defer caches.Close()
+	_ = cacher.Set(GenCacheKey("123", "mock_memberships", "42,7-a"), []byte("val"), time.Minute)
+	_ = cacher.Set(GenCacheKey("123", "mock_memberships", "42,8-b"), []byte("val"), time.Minute)
+
+	if err := caches.InvalidateKeys(context.Background(), &mockMembership{}, []uint{42, 7}); err != nil {
+		t.Fatalf("InvalidateKeys returned an unexpected error %v", err)
+	}
+
+	assertCached(t, cacher, map[string]bool{
+		GenCacheKey("123", "mock_memberships", "42,7-a"): false,
+		GenCacheKey("123", "mock_memberships", "42,8-b"): true,
+	})
+}
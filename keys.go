@@ -0,0 +1,221 @@
+package caches
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"hash"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxVarDepth bounds the nesting of the encoded vars, as pointers may be cyclic
+const maxVarDepth = 32
+
+var (
+	timeType   = reflect.TypeOf(time.Time{})
+	valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// KeyBuilder builds the keys of the Cacher, every prefix being a prefix of the keys it groups.
+// Implementations may add e.g. a service name, an environment or a deploy version to the keys.
+// The table name carries the tenant of the statement if any (see GenTenantTable), so the table prefix
+// of the bare table must also group the keys of its tenants.
+type KeyBuilder interface {
+	// QueryKey builds the key of a query result, grouped under the primary key of its first row,
+	// LIST_KEY or IDS_KEY, and identified by the hash of the query.
+	// Row entries (see Config.EntityCache) are built with an empty hash.
+	QueryKey(instanceId, tableName, group, hash string) string
+	// DetailPrefix groups the keys of the entries holding the row with the given primary key
+	DetailPrefix(instanceId, tableName, primaryKey string) string
+	// ListPrefix groups the keys of the list entries, listKey being LIST_KEY or IDS_KEY
+	ListPrefix(instanceId, tableName, listKey string) string
+	// TablePrefix groups the keys of the table
+	TablePrefix(instanceId, tableName string) string
+	// InstancePrefix groups the keys of the instance
+	InstancePrefix(instanceId string) string
+	// TagKey builds the key of a tag, see TaggedCacher
+	TagKey(instanceId, tag string) string
+	// TableName returns the table of a key it built, empty if unknown
+	TableName(key string) string
+}
+
+// DefaultKeyBuilder builds keys following CACHE_PATTERN, e.g. `INSTANCE_1:TABLE_users:5-<hash>`
+type DefaultKeyBuilder struct{}
+
+func (DefaultKeyBuilder) QueryKey(instanceId, tableName, group, hash string) string {
+	if hash == "" {
+		return GenCacheKey(instanceId, tableName, group)
+	}
+	return GenCacheKey(instanceId, tableName, group+"-"+hash)
+}
+
+func (DefaultKeyBuilder) DetailPrefix(instanceId, tableName, primaryKey string) string {
+	return GenCacheKey(instanceId, tableName, primaryKey)
+}
+
+func (DefaultKeyBuilder) ListPrefix(instanceId, tableName, listKey string) string {
+	return GenCacheKey(instanceId, tableName, listKey)
+}
+
+func (DefaultKeyBuilder) TablePrefix(instanceId, tableName string) string {
+	return GenCachePrefix(instanceId, tableName)
+}
+
+func (DefaultKeyBuilder) InstancePrefix(instanceId string) string {
+	return GenInstancePrefix(instanceId)
+}
+
+func (DefaultKeyBuilder) TagKey(instanceId, tag string) string {
+	return GenTagKey(instanceId, tag)
+}
+
+func (DefaultKeyBuilder) TableName(key string) string {
+	return getTableFromKey(key)
+}
+
+// keys returns the configured KeyBuilder, DefaultKeyBuilder if none
+func (c *Caches) keys() KeyBuilder {
+	if c.Conf.KeyBuilder != nil {
+		return c.Conf.KeyBuilder
+	}
+	return DefaultKeyBuilder{}
+}
+
+// prefixKey builds the prefix of an invalidation, see InvalidationEvent.Prefixes
+func (c *Caches) prefixKey(tableName, prefix string) string {
+	switch prefix {
+	case "":
+		return c.keys().TablePrefix(c.Conf.InstanceId, tableName)
+	case LIST_KEY, IDS_KEY:
+		return c.keys().ListPrefix(c.Conf.InstanceId, tableName, prefix)
+	}
+	return c.keys().DetailPrefix(c.Conf.InstanceId, tableName, prefix)
+}
+
+// hashQuery hashes the SQL along with the canonical encoding of its vars (see encodeVar) into a fixed-length key,
+// so keys stay short whatever the size of the query
+func hashQuery(sql string, vars []interface{}) string {
+	h := sha256.New()
+	writeString(h, sql)
+	writeLen(h, len(vars))
+	for _, v := range vars {
+		encodeVar(h, reflect.ValueOf(v), 0)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// encodeVar writes an unambiguous encoding of the var: every value is prefixed with its kind and every variable
+// length one with its length, pointers are followed, driver.Valuers are encoded as their value, times in UTC
+// and maps in key order, so equal vars always get the same encoding whatever their addresses.
+func encodeVar(h hash.Hash, v reflect.Value, depth int) {
+	if !v.IsValid() {
+		h.Write([]byte{'n'})
+		return
+	}
+	if depth > maxVarDepth {
+		h.Write([]byte{'.'})
+		return
+	}
+	depth++
+
+	if v.CanInterface() && v.Type().Implements(valuerType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			h.Write([]byte{'n'})
+			return
+		}
+		if value, err := v.Interface().(driver.Valuer).Value(); err == nil {
+			h.Write([]byte{'v'})
+			encodeVar(h, reflect.ValueOf(value), depth)
+			return
+		}
+	}
+
+	if v.CanInterface() && v.Type() == timeType {
+		h.Write([]byte{'t'})
+		writeString(h, v.Interface().(time.Time).UTC().Format(time.RFC3339Nano))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			h.Write([]byte{'n'})
+			return
+		}
+		encodeVar(h, v.Elem(), depth)
+	case reflect.Bool:
+		h.Write([]byte{'B', boolByte(v.Bool())})
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		h.Write([]byte{'i'})
+		writeString(h, strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		h.Write([]byte{'u'})
+		writeString(h, strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		h.Write([]byte{'f'})
+		writeString(h, strconv.FormatFloat(v.Float(), 'g', -1, 64))
+	case reflect.String:
+		h.Write([]byte{'s'})
+		writeString(h, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			h.Write([]byte{'b'})
+			writeString(h, string(v.Bytes()))
+			return
+		}
+		h.Write([]byte{'['})
+		writeLen(h, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			encodeVar(h, v.Index(i), depth)
+		}
+	case reflect.Map:
+		// keys are sorted by their own encoding
+		entries := make([][2]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, val := sha256.New(), sha256.New()
+			encodeVar(key, iter.Key(), depth)
+			encodeVar(val, iter.Value(), depth)
+			entries = append(entries, [2]string{string(key.Sum(nil)), string(val.Sum(nil))})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i][0] < entries[j][0] })
+
+		h.Write([]byte{'{'})
+		writeLen(h, len(entries))
+		for _, entry := range entries {
+			h.Write([]byte(entry[0]))
+			h.Write([]byte(entry[1]))
+		}
+	case reflect.Struct:
+		h.Write([]byte{'S'})
+		writeString(h, v.Type().String())
+		writeLen(h, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			encodeVar(h, v.Field(i), depth)
+		}
+	default:
+		// channels and functions, which are not valid vars anyway
+		h.Write([]byte{'?'})
+		writeString(h, v.Type().String())
+	}
+}
+
+func writeString(h hash.Hash, s string) {
+	writeLen(h, len(s))
+	h.Write([]byte(s))
+}
+
+func writeLen(h hash.Hash, n int) {
+	h.Write([]byte(strconv.Itoa(n)))
+	h.Write([]byte{':'})
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return '1'
+	}
+	return '0'
+}
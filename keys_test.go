@@ -0,0 +1,148 @@
+package caches
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type varStructMock struct {
+	Name  string
+	Inner *varStructMock
+}
+
+func Test_hashQuery(t *testing.T) {
+	one, otherOne := 1, 1
+	utc := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	equal := map[string][2][]interface{}{
+		"pointers to equal values": {{&one}, {&otherOne}},
+		"pointers to equal structs": {
+			{&varStructMock{Name: "a", Inner: &varStructMock{Name: "b"}}},
+			{&varStructMock{Name: "a", Inner: &varStructMock{Name: "b"}}},
+		},
+		"same instant in other zones": {{utc}, {utc.In(time.FixedZone("UTC+2", 2*60*60))}},
+		"valuers":                     {{sql.NullString{String: "a", Valid: true}}, {sql.NullString{String: "a", Valid: true}}},
+		"maps in any order":           {{map[string]int{"a": 1, "b": 2}}, {map[string]int{"b": 2, "a": 1}}},
+	}
+	for name, vars := range equal {
+		if hashQuery("SELECT ?", vars[0]) != hashQuery("SELECT ?", vars[1]) {
+			t.Errorf("%s: expected %v and %v to be hashed the same", name, vars[0], vars[1])
+		}
+	}
+
+	different := map[string][2][]interface{}{
+		"string and number":      {{"1"}, {1}},
+		"joined and split lists": {{[]string{"a,b"}}, {[]string{"a", "b"}}},
+		"split vars":             {{"ab", "c"}, {"a", "bc"}},
+		"bytes and string":       {{[]byte("a")}, {"a"}},
+		"null and valid valuers": {{sql.NullString{}}, {sql.NullString{Valid: true}}},
+		"nil and empty string":   {{nil}, {""}},
+		"signed and unsigned":    {{-1}, {uint(1)}},
+	}
+	for name, vars := range different {
+		if hashQuery("SELECT ?", vars[0]) == hashQuery("SELECT ?", vars[1]) {
+			t.Errorf("%s: expected %v and %v to be hashed differently", name, vars[0], vars[1])
+		}
+	}
+
+	ids := make([]interface{}, 10000)
+	for i := range ids {
+		ids[i] = i
+	}
+	if key := hashQuery("SELECT * FROM users WHERE id IN ("+strings.Repeat("?,", len(ids)-1)+"?)", ids); len(key) != 64 {
+		t.Errorf("expected keys of a fixed length whatever the query, got %d bytes", len(key))
+	}
+
+	// cyclic pointers are encoded up to a bounded depth
+	cyclic := &varStructMock{Name: "a"}
+	cyclic.Inner = cyclic
+	_ = hashQuery("SELECT ?", []interface{}{cyclic})
+}
+
+// envKeyBuilder prefixes the default keys with an environment
+type envKeyBuilder struct {
+	DefaultKeyBuilder
+	env string
+}
+
+func (b envKeyBuilder) QueryKey(instanceId, tableName, group, hash string) string {
+	return b.env + ":" + b.DefaultKeyBuilder.QueryKey(instanceId, tableName, group, hash)
+}
+
+func (b envKeyBuilder) DetailPrefix(instanceId, tableName, primaryKey string) string {
+	return b.env + ":" + b.DefaultKeyBuilder.DetailPrefix(instanceId, tableName, primaryKey)
+}
+
+func (b envKeyBuilder) ListPrefix(instanceId, tableName, listKey string) string {
+	return b.env + ":" + b.DefaultKeyBuilder.ListPrefix(instanceId, tableName, listKey)
+}
+
+func (b envKeyBuilder) TablePrefix(instanceId, tableName string) string {
+	return b.env + ":" + b.DefaultKeyBuilder.TablePrefix(instanceId, tableName)
+}
+
+func (b envKeyBuilder) InstancePrefix(instanceId string) string {
+	return b.env + ":" + b.DefaultKeyBuilder.InstancePrefix(instanceId)
+}
+
+func (b envKeyBuilder) TagKey(instanceId, tag string) string {
+	return b.env + ":" + b.DefaultKeyBuilder.TagKey(instanceId, tag)
+}
+
+func (b envKeyBuilder) TableName(key string) string {
+	return b.DefaultKeyBuilder.TableName(strings.TrimPrefix(key, b.env+":"))
+}
+
+func TestCaches_KeyBuilder(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	cacher := NewMemoryCacher(0)
+	caches := &Caches{
+		Conf: &Config{
+			InstanceId:       "1",
+			Cacher:           cacher,
+			Serializer:       JSONSerializer{},
+			SyncInvalidation: true,
+			KeyBuilder:       envKeyBuilder{env: "prod"},
+		},
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	caches.queryCb = func(tx *gorm.DB) {
+		tx.Statement.RowsAffected = 1
+	}
+
+	var users []mockUser
+	db.Find(&users)
+	db.Find(&users, []int{1, 2})
+	if err := caches.Flush(context.Background()); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	keys := make([]string, 0)
+	for key := range cacher.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) != 3 {
+		t.Fatalf("expected a list entry, a detail entry and its marker, got %v", keys)
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "prod:INSTANCE_1:TABLE_mock_users:") {
+			t.Errorf("expected the keys to be built by the KeyBuilder, got `%s`", key)
+		}
+	}
+
+	// updating the second row evicts the list and the detail entry through its marker
+	db.Model(&mockUser{ID: 2}).Update("name", "john")
+	if len(cacher.items) != 1 {
+		t.Errorf("expected the invalidation to use the KeyBuilder prefixes, left %v", cacher.items)
+	}
+}
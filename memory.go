@@ -0,0 +1,204 @@
+package caches
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MEMORY_SWEEP_INTERVAL is the minimum time between two sweeps of the expired entries of a MemoryCacher
+const MEMORY_SWEEP_INTERVAL = time.Minute
+
+type memoryItem struct {
+	val       []byte
+	expiresAt time.Time
+	tags      []string
+	// elem is the position of the entry in the recency list
+	elem *list.Element
+}
+
+func (i *memoryItem) expired(now time.Time) bool {
+	return !i.expiresAt.IsZero() && now.After(i.expiresAt)
+}
+
+// MemoryCacher is an in-process Cacher, suited for tests and as a local tier in front of a shared Cacher.
+// Expired entries are swept at most every MEMORY_SWEEP_INTERVAL, as entries are stored.
+type MemoryCacher struct {
+	// MaxEntries bounds the number of stored entries, evicting the least recently used ones when reached (unbounded if zero)
+	MaxEntries int
+
+	mu        sync.Mutex
+	items     map[string]*memoryItem
+	tags      map[string]map[string]struct{}
+	recency   *list.List // of keys, the most recently used first
+	lastSweep time.Time
+}
+
+func NewMemoryCacher(maxEntries int) *MemoryCacher {
+	return &MemoryCacher{
+		MaxEntries: maxEntries,
+		items:      make(map[string]*memoryItem),
+	}
+}
+
+func (c *MemoryCacher) Get(key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.get(key, time.Now()), nil
+}
+
+func (c *MemoryCacher) Set(key string, val []byte, ttl time.Duration) error {
+	return c.SetWithTags(key, val, ttl, nil)
+}
+
+func (c *MemoryCacher) SetWithTags(key string, val []byte, ttl time.Duration, tags []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, val, ttl, tags)
+	return nil
+}
+
+func (c *MemoryCacher) GetMulti(keys ...string) ([][]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	vals := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		vals = append(vals, c.get(key, now))
+	}
+	return vals, nil
+}
+
+func (c *MemoryCacher) SetMulti(vals map[string][]byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, val := range vals {
+		c.set(key, val, ttl, nil)
+	}
+	return nil
+}
+
+func (c *MemoryCacher) DeleteMulti(keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		c.remove(key)
+	}
+	return nil
+}
+
+func (c *MemoryCacher) InvalidateTags(tags ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, tag := range tags {
+		for key := range c.tags[tag] {
+			c.remove(key)
+		}
+	}
+	return nil
+}
+
+func (c *MemoryCacher) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+	return nil
+}
+
+func (c *MemoryCacher) DeleteWithPrefix(keyPrefix string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.items {
+		if strings.HasPrefix(key, keyPrefix) {
+			c.remove(key)
+		}
+	}
+	return nil
+}
+
+// get returns the value of the entry, marking it as the most recently used, the lock being held
+func (c *MemoryCacher) get(key string, now time.Time) []byte {
+	item, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	if item.expired(now) {
+		c.remove(key)
+		return nil
+	}
+	c.recency.MoveToFront(item.elem)
+	return item.val
+}
+
+// set stores the entry along with its tag references, the lock being held
+func (c *MemoryCacher) set(key string, val []byte, ttl time.Duration, tags []string) {
+	now := time.Now()
+	item := &memoryItem{val: val, tags: tags}
+	if ttl > 0 {
+		item.expiresAt = now.Add(ttl)
+	}
+
+	if c.items == nil {
+		c.items = make(map[string]*memoryItem)
+	}
+	if c.recency == nil {
+		c.recency = list.New()
+	}
+	c.remove(key)
+	c.sweep(now)
+	for c.MaxEntries > 0 && len(c.items) >= c.MaxEntries {
+		c.remove(c.recency.Back().Value.(string))
+	}
+
+	item.elem = c.recency.PushFront(key)
+	c.items[key] = item
+	for _, tag := range tags {
+		if c.tags == nil {
+			c.tags = make(map[string]map[string]struct{})
+		}
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][key] = struct{}{}
+	}
+}
+
+// remove deletes the entry along with its tag references
+func (c *MemoryCacher) remove(key string) {
+	item, ok := c.items[key]
+	if !ok {
+		return
+	}
+
+	delete(c.items, key)
+	c.recency.Remove(item.elem)
+	for _, tag := range item.tags {
+		delete(c.tags[tag], key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+// sweep drops the expired entries, unless swept within MEMORY_SWEEP_INTERVAL
+func (c *MemoryCacher) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < MEMORY_SWEEP_INTERVAL {
+		return
+	}
+	c.lastSweep = now
+
+	for key, item := range c.items {
+		if item.expired(now) {
+			c.remove(key)
+		}
+	}
+}
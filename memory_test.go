@@ -0,0 +1,118 @@
+package caches
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryCacher(t *testing.T) {
+	t.Run("get set delete", func(t *testing.T) {
+		cacher := NewMemoryCacher(0)
+		_ = cacher.Set("INSTANCE_1:TABLE_users:LIST-a", []byte("a"), 0)
+		_ = cacher.Set("INSTANCE_1:TABLE_users:LIST-b", []byte("b"), 0)
+		_ = cacher.Set("INSTANCE_1:TABLE_users:1-c", []byte("c"), 0)
+
+		if val, _ := cacher.Get("INSTANCE_1:TABLE_users:LIST-a"); string(val) != "a" {
+			t.Errorf("Get expected to return `a`, got `%s`", val)
+		}
+
+		_ = cacher.DeleteWithPrefix("INSTANCE_1:TABLE_users:LIST")
+		if val, _ := cacher.Get("INSTANCE_1:TABLE_users:LIST-b"); val != nil {
+			t.Errorf("DeleteWithPrefix expected to delete the listed entries, got `%s`", val)
+		}
+		if val, _ := cacher.Get("INSTANCE_1:TABLE_users:1-c"); string(val) != "c" {
+			t.Errorf("DeleteWithPrefix expected to keep the other entries, got `%s`", val)
+		}
+
+		_ = cacher.Delete("INSTANCE_1:TABLE_users:1-c")
+		if val, _ := cacher.Get("INSTANCE_1:TABLE_users:1-c"); val != nil {
+			t.Errorf("Delete expected to delete the entry, got `%s`", val)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		cacher := NewMemoryCacher(0)
+		_ = cacher.Set("key", []byte("val"), 10*time.Millisecond)
+		time.Sleep(20 * time.Millisecond)
+
+		if val, _ := cacher.Get("key"); val != nil {
+			t.Errorf("Get expected not to return expired entries, got `%s`", val)
+		}
+	})
+
+	t.Run("max entries", func(t *testing.T) {
+		cacher := NewMemoryCacher(2)
+		_ = cacher.Set("a", []byte("a"), 0)
+		_ = cacher.Set("b", []byte("b"), 0)
+		_ = cacher.Set("c", []byte("c"), 0)
+
+		if len(cacher.items) != 2 {
+			t.Errorf("expected the cacher to hold at most 2 entries, holds %d", len(cacher.items))
+		}
+		if val, _ := cacher.Get("c"); string(val) != "c" {
+			t.Errorf("expected the latest entry to be stored, got `%s`", val)
+		}
+	})
+
+	t.Run("least recently used", func(t *testing.T) {
+		cacher := NewMemoryCacher(2)
+		_ = cacher.Set("a", []byte("a"), 0)
+		_ = cacher.Set("b", []byte("b"), 0)
+		_, _ = cacher.Get("a")
+		_ = cacher.Set("c", []byte("c"), 0)
+
+		if val, _ := cacher.Get("b"); val != nil {
+			t.Errorf("expected the least recently used entry to be evicted, got `%s`", val)
+		}
+		if val, _ := cacher.Get("a"); string(val) != "a" {
+			t.Errorf("expected the recently read entry to be kept, got `%s`", val)
+		}
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		cacher := NewMemoryCacher(0)
+		_ = cacher.Set("a", []byte("a"), time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+
+		cacher.lastSweep = time.Now().Add(-MEMORY_SWEEP_INTERVAL)
+		_ = cacher.Set("b", []byte("b"), 0)
+
+		if len(cacher.items) != 1 || cacher.recency.Len() != 1 {
+			t.Errorf("expected the expired entries to be swept, holds %v", cacher.items)
+		}
+	})
+}
+
+func TestMemoryCacher_Tags(t *testing.T) {
+	cacher := NewMemoryCacher(0)
+	_ = cacher.SetWithTags("a", []byte("a"), 0, []string{"tenant:1", "users"})
+	_ = cacher.SetWithTags("b", []byte("b"), 0, []string{"tenant:2", "users"})
+
+	_ = cacher.InvalidateTags("tenant:1")
+	if val, _ := cacher.Get("a"); val != nil {
+		t.Error("InvalidateTags expected to delete the tagged entries")
+	}
+	if val, _ := cacher.Get("b"); val == nil {
+		t.Error("InvalidateTags expected to keep the other entries")
+	}
+
+	_ = cacher.Delete("b")
+	if len(cacher.tags) != 0 {
+		t.Errorf("expected the tag references to be dropped along with the entries, left %v", cacher.tags)
+	}
+}
+
+func TestMemoryCacher_Batch(t *testing.T) {
+	cacher := NewMemoryCacher(0)
+	_ = cacher.SetMulti(map[string][]byte{"a": []byte("a"), "b": []byte("b")}, time.Minute)
+
+	vals, _ := cacher.GetMulti("a", "missing", "b")
+	if len(vals) != 3 || string(vals[0]) != "a" || vals[1] != nil || string(vals[2]) != "b" {
+		t.Errorf("GetMulti expected to return [a <nil> b], got %q", vals)
+	}
+
+	_ = cacher.DeleteMulti("a", "b")
+	if vals, _ := cacher.GetMulti("a", "b"); vals[0] != nil || vals[1] != nil {
+		t.Errorf("DeleteMulti expected to delete the entries, got %q", vals)
+	}
+}
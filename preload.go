@@ -0,0 +1,124 @@
+package caches
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const PRELOAD_GRAPH_KEY = "gorm:caches:preload_graph"
+
+// pendingGraph is a preloaded graph to store once its preloads are done, see AfterPreload
+type pendingGraph struct {
+	identifier string
+	start      *queryStart
+}
+
+// preloadGraph returns the tables the preloads of the statement read from, besides its own,
+// reporting false unless the statement is cached as a whole (see Config.PreloadCache)
+func (c *Caches) preloadGraph(db *gorm.DB) ([]string, bool) {
+	if !c.Conf.PreloadCache || len(db.Statement.Preloads) == 0 || db.Statement.Schema == nil {
+		return nil, false
+	}
+
+	tableName := getTableName(db)
+	seen := map[string]struct{}{tableName: {}}
+	tables := make([]string, 0)
+	add := func(tableName string) {
+		if _, ok := seen[tableName]; !ok {
+			seen[tableName] = struct{}{}
+			tables = append(tables, tableName)
+		}
+	}
+
+	for name, args := range db.Statement.Preloads {
+		// scopes cannot be told apart by the key of the entry
+		for _, arg := range args {
+			if reflect.ValueOf(arg).Kind() == reflect.Func {
+				return nil, false
+			}
+		}
+
+		relations, ok := preloadRelations(db.Statement.Schema, name)
+		if !ok {
+			return nil, false
+		}
+		for _, rel := range relations {
+			add(rel.FieldSchema.Table)
+			if rel.JoinTable != nil {
+				add(rel.JoinTable.Table)
+			}
+		}
+	}
+
+	for _, tableName := range tables {
+		if c.tableIgnoredCache(tableName) {
+			return nil, false
+		}
+	}
+	sort.Strings(tables)
+	return tables, true
+}
+
+// preloadRelations resolves the relations a preload goes through, e.g. `Orders.Items` or clause.Associations
+func preloadRelations(s *schema.Schema, name string) ([]*schema.Relationship, bool) {
+	relations := make([]*schema.Relationship, 0)
+	for _, part := range strings.Split(name, ".") {
+		if part == clause.Associations {
+			for _, rel := range s.Relationships.Relations {
+				relations = append(relations, rel)
+			}
+			return relations, true
+		}
+
+		rel, ok := s.Relationships.Relations[part]
+		if !ok {
+			return nil, false
+		}
+		relations = append(relations, rel)
+		s = rel.FieldSchema
+	}
+	return relations, true
+}
+
+// preloadSignature lists the preloads of the statement along with their conditions, in a stable order
+func preloadSignature(db *gorm.DB) []interface{} {
+	names := make([]string, 0, len(db.Statement.Preloads))
+	for name := range db.Statement.Preloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	signature := make([]interface{}, 0, 2*len(names))
+	for _, name := range names {
+		signature = append(signature, name, db.Statement.Preloads[name])
+	}
+	return signature
+}
+
+// queryHash hashes the statement, along with its preloads when cached as a whole
+func (c *Caches) queryHash(db *gorm.DB) string {
+	vars := db.Statement.Vars
+	if _, ok := c.preloadGraph(db); ok {
+		vars = append(append(make([]interface{}, 0, len(vars)+1), vars...), preloadSignature(db))
+	}
+	return hashQuery(db.Statement.SQL.String(), vars)
+}
+
+// AfterPreload stores the preloaded graphs, which are complete only once the preloads are done
+func (c *Caches) AfterPreload(db *gorm.DB) {
+	pending, ok := db.InstanceGet(PRELOAD_GRAPH_KEY)
+	if !ok {
+		return
+	}
+	if db.Error != nil && !c.negativeResult(db) {
+		return
+	}
+
+	graph := pending.(pendingGraph)
+	c.storeInCache(db, graph.identifier, graph.start)
+}
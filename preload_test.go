@@ -0,0 +1,170 @@
+package caches
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/utils/tests"
+)
+
+type mockAuthor struct {
+	ID    uint
+	Name  string
+	Books []mockBook `gorm:"foreignKey:AuthorID"`
+	Tags  []mockTag  `gorm:"many2many:mock_author_tags"`
+}
+
+type mockBook struct {
+	ID       uint
+	AuthorID uint
+	Title    string
+}
+
+type mockTag struct {
+	ID   uint
+	Name string
+}
+
+func Test_preloadGraph(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	caches := &Caches{Conf: &Config{PreloadCache: true}}
+	newStatement := func(scope func(*gorm.DB) *gorm.DB) *gorm.DB {
+		tx := scope(db.Session(&gorm.Session{NewDB: true}).Model(&mockAuthor{}))
+		if err := tx.Statement.Parse(&mockAuthor{}); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		return tx
+	}
+
+	for name, tc := range map[string]struct {
+		db       *gorm.DB
+		expected []string
+		ok       bool
+	}{
+		"associations": {
+			db: newStatement(func(tx *gorm.DB) *gorm.DB {
+				return tx.Preload("Books", "title <> ?", "").Preload("Tags")
+			}),
+			expected: []string{"mock_author_tags", "mock_books", "mock_tags"},
+			ok:       true,
+		},
+		"all associations": {
+			db: newStatement(func(tx *gorm.DB) *gorm.DB {
+				return tx.Preload(clause.Associations)
+			}),
+			expected: []string{"mock_author_tags", "mock_books", "mock_tags"},
+			ok:       true,
+		},
+		"scoped preload": {
+			db: newStatement(func(tx *gorm.DB) *gorm.DB {
+				return tx.Preload("Books", func(tx *gorm.DB) *gorm.DB {
+					return tx.Where("title <> ?", "")
+				})
+			}),
+		},
+		"unknown relation": {
+			db: newStatement(func(tx *gorm.DB) *gorm.DB {
+				return tx.Preload("Reviews")
+			}),
+		},
+		"without preloads": {
+			db: newStatement(func(tx *gorm.DB) *gorm.DB {
+				return tx
+			}),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tables, ok := caches.preloadGraph(tc.db)
+			if ok != tc.ok || !reflect.DeepEqual(tables, tc.expected) {
+				t.Errorf("preloadGraph expected to return %v %t but got %v %t", tc.expected, tc.ok, tables, ok)
+			}
+		})
+	}
+}
+
+func TestCaches_PreloadCache(t *testing.T) {
+	for _, preloadCache := range []bool{true, false} {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+		caches := &Caches{
+			Conf: &Config{
+				InstanceId:       "1",
+				Cacher:           NewMemoryCacher(0),
+				Serializer:       JSONSerializer{},
+				SyncInvalidation: true,
+				PreloadCache:     preloadCache,
+			},
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+
+		var queries int32
+		caches.queryCb = func(tx *gorm.DB) {
+			atomic.AddInt32(&queries, 1)
+			tx.Statement.RowsAffected = 1
+			switch dest := tx.Statement.Dest.(type) {
+			case *[]mockAuthor:
+				*dest = []mockAuthor{{ID: 1, Name: "john"}}
+			case *[]*mockBook:
+				*dest = []*mockBook{{ID: 7, AuthorID: 1, Title: "gorm"}}
+			default:
+				tx.Statement.RowsAffected = 0
+			}
+		}
+
+		find := func() []mockAuthor {
+			var authors []mockAuthor
+			if err := db.Preload("Books").Find(&authors).Error; err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+			if err := caches.Flush(context.Background()); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+			return authors
+		}
+		expected := []mockAuthor{{ID: 1, Name: "john", Books: []mockBook{{ID: 7, AuthorID: 1, Title: "gorm"}}}}
+
+		if authors := find(); !reflect.DeepEqual(authors, expected) {
+			t.Fatalf("expected the authors to be preloaded as %v, got %v", expected, authors)
+		}
+		if actual := atomic.LoadInt32(&queries); actual != 2 {
+			t.Fatalf("expected the authors and their books to be queried, ran %d queries", actual)
+		}
+
+		atomic.StoreInt32(&queries, 0)
+		if authors := find(); !reflect.DeepEqual(authors, expected) {
+			t.Errorf("expected the cached authors to be preloaded as %v, got %v", expected, authors)
+		}
+		if actual := atomic.LoadInt32(&queries); actual != 0 {
+			t.Errorf("expected the preloaded authors to be cached (PreloadCache %t), ran %d queries", preloadCache, actual)
+		}
+
+		if preloadCache {
+			// the graph is not shared with the query without preloads
+			var authors []mockAuthor
+			db.Find(&authors)
+			if len(authors) != 1 || authors[0].Books != nil {
+				t.Errorf("expected the authors without their books, got %v", authors)
+			}
+		}
+
+		// writes to the table of a preload evict the graph
+		db.Model(&mockBook{}).Where("id = ?", 7).Update("title", "gorm v2")
+		atomic.StoreInt32(&queries, 0)
+		if authors := find(); !reflect.DeepEqual(authors, expected) {
+			t.Errorf("expected the authors to be preloaded as %v, got %v", expected, authors)
+		}
+		// the rows of the graph are queried again, while the authors alone are still cached otherwise
+		expectedQueries := int32(1)
+		if preloadCache {
+			expectedQueries = 2
+		}
+		if actual := atomic.LoadInt32(&queries); actual != expectedQueries {
+			t.Errorf("expected %d queries after the write (PreloadCache %t), ran %d", expectedQueries, preloadCache, actual)
+		}
+	}
+}
@@ -1,12 +1,29 @@
 package caches
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type Query struct {
 	Dest         interface{}
 	RowsAffected int64
+	// NotFound marks a negative entry, which replays gorm.ErrRecordNotFound on hit
+	NotFound bool
+
+	// ExpiresAt is the moment the entry expires from the Cacher, zero if it never does
+	ExpiresAt time.Time
+	// Delta is how long the query took to compute, used for probabilistic early expiration
+	Delta time.Duration
+
+	// IDList marks an ID-list entry, holding the PrimaryKeys of its rows in order instead of Dest,
+	// the rows being cached in their own entries (see Config.IDListCache)
+	IDList      bool
+	PrimaryKeys []string
+
+	// StaleAt is the moment after which the entry is served stale and refreshed in background
+	StaleAt time.Time
 }
 
 func (q *Query) replaceOn(db *gorm.DB) {
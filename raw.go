@@ -0,0 +1,349 @@
+package caches
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+)
+
+const TABLES_KEY = "gorm:caches:tables"
+
+var rawValuesType = reflect.TypeOf([][]interface{}{})
+
+// WithTables opts the Row, Rows and Raw().Scan queries run with the returned context into caching,
+// their entries being evicted by the writes to any of the given tables they read from
+func WithTables(ctx context.Context, tables ...string) context.Context {
+	return context.WithValue(ctx, TABLES_KEY, tables)
+}
+
+// DependsOn is the scope counterpart of WithTables, e.g.
+// `db.Scopes(caches.DependsOn("users", "orders")).Raw(report).Scan(&rows)`
+func DependsOn(tables ...string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Set(TABLES_KEY, tables)
+	}
+}
+
+// dependencies returns the tables the statement declares to read from, see WithTables and DependsOn
+func dependencies(db *gorm.DB) []string {
+	if tables, ok := db.Get(TABLES_KEY); ok {
+		if tables, ok := tables.([]string); ok && len(tables) > 0 {
+			return tables
+		}
+	}
+	if ctx := db.Statement.Context; ctx != nil {
+		if tables, ok := ctx.Value(TABLES_KEY).([]string); ok {
+			return tables
+		}
+	}
+	return nil
+}
+
+// rawRows holds the rows read by a Row or Rows query, replayed to the caller as *sql.Rows or *sql.Row
+type rawRows struct {
+	columns []string
+	values  [][]interface{}
+}
+
+// cachedRows is the form rawRows are cached in, see TypedValue
+type cachedRows struct {
+	Columns []string
+	Values  [][]TypedValue
+}
+
+// Row caches the rows of the Row, Rows and Raw().Scan queries declaring the tables they read from (see WithTables),
+// under the list key of the first table and markers in the list keys of the others, so writes to any of them evict it.
+// Queries are always read as rows from the database, and replayed from memory as the caller asked.
+func (c *Caches) Row(db *gorm.DB) {
+	tables := dependencies(db)
+	if db.Error != nil || len(tables) == 0 || c.rawIgnoredCache(db, tables) {
+		c.rowCb(db)
+		return
+	}
+
+	callbacks.BuildQuerySQL(db)
+	if db.Error != nil {
+		return
+	}
+
+	asRows := false
+	if isRows, ok := db.Get("rows"); ok {
+		asRows, _ = isRows.(bool)
+	}
+
+	identifier, markerKeys := c.buildRawIdentifier(db, tables)
+	if rows, ok := c.checkRows(db, identifier, markerKeys); ok {
+		c.replayRows(db, rows, nil, asRows)
+		return
+	} else if db.Error != nil {
+		return
+	}
+
+	start := c.startQuery(c.tenant(db.Statement.Context), tables...)
+	db.Statement.Settings.Store("rows", true)
+	c.rowCb(db)
+
+	sqlRows, ok := db.Statement.Dest.(*sql.Rows)
+	if !ok {
+		return
+	}
+	err := db.Error
+	var rows *rawRows
+	if err == nil && sqlRows != nil {
+		rows, err = readRows(sqlRows)
+	}
+	if err != nil {
+		db.Error = nil
+		c.replayRows(db, nil, err, asRows)
+		return
+	}
+
+	c.storeRows(db, identifier, tables, markerKeys, rows, start)
+	c.replayRows(db, rows, nil, asRows)
+}
+
+func (c *Caches) rawIgnoredCache(db *gorm.DB, tables []string) bool {
+	if c.cacher() == nil || (db.Statement.Context != nil && c.ctxIgnoredCache(db.Statement.Context)) {
+		return true
+	}
+	for _, tableName := range tables {
+		if c.tableIgnoredCache(tableName) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildRawIdentifier keys the query under the list key of its first table, along with the markers
+// of the others (see buildMarkerKeys)
+func (c *Caches) buildRawIdentifier(db *gorm.DB, tables []string) (string, []string) {
+	hash := hashQuery(db.Statement.SQL.String(), db.Statement.Vars)
+	tenant := c.tenant(db.Statement.Context)
+
+	markerKeys := make([]string, 0, len(tables)-1)
+	for _, tableName := range tables[1:] {
+		markerKeys = append(markerKeys, c.keys().QueryKey(c.Conf.InstanceId, keyTable(tableName, tenant), LIST_KEY, hash))
+	}
+	return c.keys().QueryKey(c.Conf.InstanceId, keyTable(tables[0], tenant), LIST_KEY, hash), markerKeys
+}
+
+// checkRows looks the rows of the query up, failing the statement if the Cacher cannot be read under FailClosed
+func (c *Caches) checkRows(db *gorm.DB, identifier string, markerKeys []string) (*rawRows, bool) {
+	keys := append([]string{identifier}, markerKeys...)
+	res, err := getMulti(c.cacher(), keys)
+	if err != nil && c.Conf.CacherFailurePolicy == FailClosed {
+		_ = db.AddError(err)
+		return nil, false
+	}
+	if err != nil {
+		return nil, false
+	}
+	for _, val := range res {
+		if val == nil {
+			return nil, false
+		}
+	}
+
+	var query Query
+	if err := c.Conf.Serializer.Deserialize(res[0], &query); err != nil || c.expiresEarly(query) {
+		return nil, false
+	}
+
+	serializedDest, err := c.Conf.Serializer.Serialize(query.Dest)
+	if err != nil {
+		return nil, false
+	}
+	var cached cachedRows
+	if err := c.Conf.Serializer.Deserialize(serializedDest, &cached); err != nil {
+		return nil, false
+	}
+	values, err := fromTyped(reflect.ValueOf(cached.Values), rawValuesType)
+	if err != nil {
+		return nil, false
+	}
+	return &rawRows{columns: cached.Columns, values: values.Interface().([][]interface{})}, true
+}
+
+// storeRows writes the rows of the query to the Cacher, on the worker pool
+func (c *Caches) storeRows(db *gorm.DB, identifier string, tables, markerKeys []string, rows *rawRows, start *queryStart) {
+	values, err := typedDest(rows.values)
+	if err != nil {
+		db.Logger.Error(db.Statement.Context, "[storeRows - Serialize] %s", err)
+		return
+	}
+
+	ttl := c.cacheTTL()
+	now := time.Now()
+	query := Query{
+		Dest:  cachedRows{Columns: rows.columns, Values: values.([][]TypedValue)},
+		Delta: now.Sub(start.time),
+	}
+	if ttl > 0 {
+		query.ExpiresAt = now.Add(ttl)
+	}
+
+	cachedData, err := c.Conf.Serializer.Serialize(query)
+	if err != nil {
+		db.Logger.Error(db.Statement.Context, "[storeRows - Serialize] %s", err)
+		return
+	}
+
+	entry := &cacheEntry{
+		identifier: identifier,
+		data:       cachedData,
+		ttl:        ttl,
+		markerKeys: markerKeys,
+		start:      start,
+	}
+	if _, ok := c.cacher().(TaggedCacher); ok {
+		tags := make([]string, 0, len(tables))
+		for _, tableName := range tables {
+			tags = append(tags, TableTag(tableName))
+		}
+		entry.tags = c.tagKeys(db, tags)
+	}
+
+	logger, ctx := db.Logger, db.Statement.Context
+	c.async(func() {
+		c.setCache(logger, ctx, entry)
+	})
+}
+
+// replayRows binds the rows, or the error reading them, onto Statement.Dest as the RowQuery callback would
+func (c *Caches) replayRows(db *gorm.DB, rows *rawRows, err error, asRows bool) {
+	var arg interface{} = rows
+	if err != nil {
+		arg = err
+	}
+
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if asRows {
+		db.Statement.Dest, db.Error = rawRowsDB().QueryContext(ctx, "", arg)
+	} else {
+		db.Statement.Dest = rawRowsDB().QueryRowContext(ctx, "", arg)
+	}
+	db.RowsAffected = -1
+}
+
+// readRows reads every row, closing them
+func readRows(rows *sql.Rows) (*rawRows, error) {
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &rawRows{columns: columns, values: make([][]interface{}, 0)}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		result.values = append(result.values, values)
+	}
+	return result, rows.Err()
+}
+
+var (
+	rawRowsOnce sync.Once
+	rawRowsPool *sql.DB
+)
+
+// rawRowsDB is a database replaying the rawRows (or the error) given as its only query argument
+func rawRowsDB() *sql.DB {
+	rawRowsOnce.Do(func() {
+		rawRowsPool = sql.OpenDB(rawRowsConnector{})
+	})
+	return rawRowsPool
+}
+
+var errRawRowsUnsupported = errors.New("gorm:caches: only replays cached rows")
+
+type rawRowsConnector struct{}
+
+func (rawRowsConnector) Connect(context.Context) (driver.Conn, error) {
+	return rawRowsConn{}, nil
+}
+
+func (rawRowsConnector) Driver() driver.Driver {
+	return rawRowsDriver{}
+}
+
+type rawRowsDriver struct{}
+
+func (rawRowsDriver) Open(string) (driver.Conn, error) {
+	return rawRowsConn{}, nil
+}
+
+type rawRowsConn struct{}
+
+func (rawRowsConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errRawRowsUnsupported
+}
+
+func (rawRowsConn) Close() error {
+	return nil
+}
+
+func (rawRowsConn) Begin() (driver.Tx, error) {
+	return nil, errRawRowsUnsupported
+}
+
+// CheckNamedValue passes the replayed rows through to QueryContext
+func (rawRowsConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (rawRowsConn) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	if len(args) != 1 {
+		return nil, errRawRowsUnsupported
+	}
+
+	switch arg := args[0].Value.(type) {
+	case *rawRows:
+		return &rawRowsIterator{rows: arg}, nil
+	case error:
+		return nil, arg
+	}
+	return nil, errRawRowsUnsupported
+}
+
+type rawRowsIterator struct {
+	rows *rawRows
+	next int
+}
+
+func (r *rawRowsIterator) Columns() []string {
+	return r.rows.columns
+}
+
+func (r *rawRowsIterator) Close() error {
+	return nil
+}
+
+func (r *rawRowsIterator) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows.values) {
+		return io.EOF
+	}
+	for i, val := range r.rows.values[r.next] {
+		dest[i] = val
+	}
+	r.next++
+	return nil
+}
@@ -0,0 +1,174 @@
+package caches
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type mockReport struct {
+	Name   string
+	Orders int64
+}
+
+func Test_rawRowsDB(t *testing.T) {
+	rows := &rawRows{
+		columns: []string{"name", "orders"},
+		values:  [][]interface{}{{"john", int64(3)}, {"jane", int64(5)}},
+	}
+
+	sqlRows, err := rawRowsDB().QueryContext(context.Background(), "", rows)
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	read, err := readRows(sqlRows)
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	if !reflect.DeepEqual(read, rows) {
+		t.Errorf("expected the rows to be replayed as %v, got %v", rows, read)
+	}
+
+	failure := errors.New("connection refused")
+	var name string
+	if err := rawRowsDB().QueryRowContext(context.Background(), "", failure).Scan(&name); !errors.Is(err, failure) {
+		t.Errorf("expected the error to be replayed, got %v", err)
+	}
+}
+
+func TestCaches_Row(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	caches := &Caches{
+		Conf: &Config{
+			InstanceId:       "1",
+			Cacher:           NewMemoryCacher(0),
+			Serializer:       JSONSerializer{},
+			SyncInvalidation: true,
+		},
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	var queries int32
+	caches.rowCb = func(tx *gorm.DB) {
+		atomic.AddInt32(&queries, 1)
+		rows := &rawRows{
+			columns: []string{"name", "orders"},
+			values:  [][]interface{}{{"john", int64(3)}, {"jane", int64(5)}},
+		}
+		if isRows, _ := tx.Get("rows"); isRows == true {
+			tx.Statement.Settings.Delete("rows")
+			tx.Statement.Dest, tx.Error = rawRowsDB().QueryContext(tx.Statement.Context, "", rows)
+			return
+		}
+		tx.Statement.Dest = rawRowsDB().QueryRowContext(tx.Statement.Context, "", rows)
+	}
+
+	report := "SELECT name, COUNT(*) AS orders FROM users JOIN orders ON orders.user_id = users.id GROUP BY name"
+	expected := []mockReport{{Name: "john", Orders: 3}, {Name: "jane", Orders: 5}}
+	scan := func(tx *gorm.DB) {
+		var reports []mockReport
+		if err := tx.Raw(report).Scan(&reports).Error; err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if !reflect.DeepEqual(reports, expected) {
+			t.Errorf("expected the report to be %v, got %v", expected, reports)
+		}
+	}
+	flush := func() {
+		if err := caches.Flush(context.Background()); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+	}
+
+	t.Run("without dependencies", func(t *testing.T) {
+		atomic.StoreInt32(&queries, 0)
+		scan(db)
+		flush()
+		scan(db)
+		if actual := atomic.LoadInt32(&queries); actual != 2 {
+			t.Errorf("expected queries without dependencies not to be cached, ran %d queries", actual)
+		}
+	})
+
+	ctx := WithTables(context.Background(), "users", "orders")
+	for name, invalidate := range map[string]func(){
+		"evicted by the first table": func() {
+			db.Table("users").Where("id = ?", 1).Update("name", "john")
+		},
+		"evicted by the other tables": func() {
+			db.Table("orders").Create(map[string]interface{}{"user_id": 1})
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := caches.InvalidateAll(context.Background()); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+			atomic.StoreInt32(&queries, 0)
+			scan(db.WithContext(ctx))
+			flush()
+			scan(db.WithContext(ctx))
+			if actual := atomic.LoadInt32(&queries); actual != 1 {
+				t.Fatalf("expected the second query to hit the cache, ran %d queries", actual)
+			}
+
+			invalidate()
+			scan(db.WithContext(ctx))
+			if actual := atomic.LoadInt32(&queries); actual != 2 {
+				t.Errorf("expected the write to evict the entry, ran %d queries", actual)
+			}
+			flush()
+		})
+	}
+
+	t.Run("Row", func(t *testing.T) {
+		atomic.StoreInt32(&queries, 0)
+		for i := 0; i < 2; i++ {
+			var (
+				name   string
+				orders int64
+			)
+			row := db.Scopes(DependsOn("users")).Raw("SELECT name, orders FROM users LIMIT 1").Row()
+			if err := row.Scan(&name, &orders); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+			if name != "john" || orders != 3 {
+				t.Errorf("expected the first row to be replayed, got %s %d", name, orders)
+			}
+			flush()
+		}
+		if actual := atomic.LoadInt32(&queries); actual != 1 {
+			t.Errorf("expected the second query to hit the cache, ran %d queries", actual)
+		}
+	})
+
+	t.Run("errors are not cached", func(t *testing.T) {
+		failure := errors.New("connection refused")
+		rowCb := caches.rowCb
+		defer func() { caches.rowCb = rowCb }()
+		var failures int32
+		caches.rowCb = func(tx *gorm.DB) {
+			atomic.AddInt32(&failures, 1)
+			tx.Statement.Dest, tx.Error = (*sql.Rows)(nil), failure
+		}
+
+		var name string
+		tx := db.WithContext(ctx).Raw("SELECT name FROM users WHERE id = ?", 42)
+		if err := tx.Row().Scan(&name); !errors.Is(err, failure) {
+			t.Errorf("expected the error to be replayed on the row, got %v", err)
+		}
+		if _, err := db.WithContext(ctx).Raw("SELECT name FROM users WHERE id = ?", 42).Rows(); !errors.Is(err, failure) {
+			t.Errorf("expected the error to be returned with the rows, got %v", err)
+		}
+		if actual := atomic.LoadInt32(&failures); actual != 2 {
+			t.Errorf("expected the failed query not to be cached, ran %d queries", actual)
+		}
+	})
+}
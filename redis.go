@@ -0,0 +1,150 @@
+package caches
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// RedisClient is the subset of a Redis client the RedisCacher relies on,
+// see the README for an implementation on top of github.com/redis/go-redis
+type RedisClient interface {
+	// Get returns nil without error when the key does not exist
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, val []byte, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) error
+	// Scan returns every key matching the glob-style pattern
+	Scan(ctx context.Context, match string) ([]string, error)
+
+	// SAddWithTTL adds the members to the set, making it live at least the given TTL: the TTL of an existing set
+	// is only ever extended, and removed if zero. It has to be atomic, e.g. through a Lua script.
+	SAddWithTTL(ctx context.Context, key string, ttl time.Duration, members ...string) error
+	SMembers(ctx context.Context, key string) ([]string, error)
+}
+
+// RedisBatchClient is an optional extension of the RedisClient, letting the RedisCacher read and write
+// several keys in a single round trip
+type RedisBatchClient interface {
+	RedisClient
+
+	// MGet returns the values of the keys in the same order, nil for the missing ones
+	MGet(ctx context.Context, keys ...string) ([][]byte, error)
+	// MSetWithTTL stores every value under its key with the same TTL, e.g. through a pipeline
+	MSetWithTTL(ctx context.Context, vals map[string][]byte, ttl time.Duration) error
+	// SAddMultiWithTTL adds the member to every set as SAddWithTTL does, e.g. through a pipeline
+	SAddMultiWithTTL(ctx context.Context, keys []string, ttl time.Duration, member string) error
+}
+
+// RedisCacher is a Cacher over Redis, supporting tags through Redis sets
+type RedisCacher struct {
+	Client RedisClient
+}
+
+func (c *RedisCacher) Get(key string) ([]byte, error) {
+	return c.Client.Get(context.Background(), key)
+}
+
+func (c *RedisCacher) Set(key string, val []byte, ttl time.Duration) error {
+	return c.Client.Set(context.Background(), key, val, ttl)
+}
+
+func (c *RedisCacher) Delete(key string) error {
+	return c.Client.Del(context.Background(), key)
+}
+
+func (c *RedisCacher) DeleteWithPrefix(keyPrefix string) error {
+	ctx := context.Background()
+	keys, err := c.Client.Scan(ctx, escapeGlob(keyPrefix)+"*")
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	return c.Client.Del(ctx, keys...)
+}
+
+// GetMulti reads the keys with a single MGET if the client supports it, one by one otherwise
+func (c *RedisCacher) GetMulti(keys ...string) ([][]byte, error) {
+	if client, ok := c.Client.(RedisBatchClient); ok {
+		return client.MGet(context.Background(), keys...)
+	}
+
+	vals := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		val, err := c.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
+
+// SetMulti stores the values at once if the client supports it, one by one otherwise
+func (c *RedisCacher) SetMulti(vals map[string][]byte, ttl time.Duration) error {
+	if client, ok := c.Client.(RedisBatchClient); ok {
+		return client.MSetWithTTL(context.Background(), vals, ttl)
+	}
+
+	for key, val := range vals {
+		if err := c.Set(key, val, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *RedisCacher) DeleteMulti(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.Client.Del(context.Background(), keys...)
+}
+
+// SetWithTags stores the entry, and adds its key to the set of every tag, at once if the client supports it
+// (lists carrying a tag per row). The sets outlive every key they hold, so the tags keep reaching the entries
+// stored with longer TTLs.
+func (c *RedisCacher) SetWithTags(key string, val []byte, ttl time.Duration, tags []string) error {
+	ctx := context.Background()
+	if err := c.Client.Set(ctx, key, val, ttl); err != nil {
+		return err
+	}
+
+	if client, ok := c.Client.(RedisBatchClient); ok && len(tags) > 0 {
+		return client.SAddMultiWithTTL(ctx, tags, ttl, key)
+	}
+	for _, tag := range tags {
+		if err := c.Client.SAddWithTTL(ctx, tag, ttl, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InvalidateTags deletes the keys in the set of every tag, along with the sets, with a single DEL
+func (c *RedisCacher) InvalidateTags(tags ...string) error {
+	ctx := context.Background()
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		members, err := c.Client.SMembers(ctx, tag)
+		if err != nil {
+			return err
+		}
+		keys = append(append(keys, members...), tag)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.Client.Del(ctx, keys...)
+}
+
+// escapeGlob escapes the characters with a special meaning in Redis glob-style patterns
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '^', '-', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
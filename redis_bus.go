@@ -0,0 +1,61 @@
+package caches
+
+import (
+	"context"
+)
+
+const DEFAULT_REDIS_CHANNEL = "gorm:caches:invalidations"
+
+// RedisPubSubClient is the subset of a Redis client the RedisBus relies on,
+// see the README for an implementation on top of github.com/redis/go-redis
+type RedisPubSubClient interface {
+	Publish(ctx context.Context, channel string, message []byte) error
+	// Subscribe calls the handler with every message published on the channel, until the returned func is called
+	Subscribe(ctx context.Context, channel string, handler func(message []byte)) (unsubscribe func(), err error)
+}
+
+// RedisBus is an InvalidationBus over Redis pub/sub
+type RedisBus struct {
+	Client     RedisPubSubClient
+	Serializer Serializer
+	// Channel defaults to DEFAULT_REDIS_CHANNEL
+	Channel string
+
+	// OnError is called with the messages which could not be deserialized
+	OnError func(err error)
+}
+
+func (b *RedisBus) Publish(ctx context.Context, event InvalidationEvent) error {
+	message, err := b.serializer().Serialize(event)
+	if err != nil {
+		return err
+	}
+	return b.Client.Publish(ctx, b.channel(), message)
+}
+
+func (b *RedisBus) Subscribe(handler func(InvalidationEvent)) (func(), error) {
+	return b.Client.Subscribe(context.Background(), b.channel(), func(message []byte) {
+		var event InvalidationEvent
+		if err := b.serializer().Deserialize(message, &event); err != nil {
+			if b.OnError != nil {
+				b.OnError(err)
+			}
+			return
+		}
+		handler(event)
+	})
+}
+
+func (b *RedisBus) channel() string {
+	if b.Channel == "" {
+		return DEFAULT_REDIS_CHANNEL
+	}
+	return b.Channel
+}
+
+func (b *RedisBus) serializer() Serializer {
+	if b.Serializer == nil {
+		return JSONSerializer{}
+	}
+	return b.Serializer
+}
@@ -0,0 +1,52 @@
+package caches
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type redisPubSubMock struct {
+	handlers map[string][]func([]byte)
+}
+
+func (r *redisPubSubMock) Publish(ctx context.Context, channel string, message []byte) error {
+	for _, handler := range r.handlers[channel] {
+		handler(message)
+	}
+	return nil
+}
+
+func (r *redisPubSubMock) Subscribe(ctx context.Context, channel string, handler func([]byte)) (func(), error) {
+	if r.handlers == nil {
+		r.handlers = make(map[string][]func([]byte))
+	}
+	r.handlers[channel] = append(r.handlers[channel], handler)
+	return func() {
+		delete(r.handlers, channel)
+	}, nil
+}
+
+func TestRedisBus(t *testing.T) {
+	var received []InvalidationEvent
+	client := &redisPubSubMock{}
+	bus := &RedisBus{Client: client}
+
+	if _, err := bus.Subscribe(func(event InvalidationEvent) {
+		received = append(received, event)
+	}); err != nil {
+		t.Fatalf("Subscribe returned an unexpected error %v", err)
+	}
+
+	event := InvalidationEvent{InstanceId: "A", Table: "users", Prefixes: []string{LIST_KEY, "1"}}
+	if err := bus.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Publish returned an unexpected error %v", err)
+	}
+
+	if len(client.handlers[DEFAULT_REDIS_CHANNEL]) != 1 {
+		t.Errorf("expected the bus to subscribe to the default channel")
+	}
+	if len(received) != 1 || !reflect.DeepEqual(received[0], event) {
+		t.Errorf("expected to receive %+v, got %+v", event, received)
+	}
+}
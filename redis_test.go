@@ -0,0 +1,222 @@
+package caches
+
+import (
+	"context"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// redisClientMock is an in-memory RedisClient, ignoring TTLs but those of the sets
+type redisClientMock struct {
+	mu      sync.Mutex
+	vals    map[string][]byte
+	sets    map[string]map[string]struct{}
+	setTTLs map[string]time.Duration // zero for sets without expiry
+}
+
+func newRedisClientMock() *redisClientMock {
+	return &redisClientMock{
+		vals:    make(map[string][]byte),
+		sets:    make(map[string]map[string]struct{}),
+		setTTLs: make(map[string]time.Duration),
+	}
+}
+
+func (r *redisClientMock) Get(ctx context.Context, key string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.vals[key], nil
+}
+
+func (r *redisClientMock) Set(ctx context.Context, key string, val []byte, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.vals[key] = val
+	return nil
+}
+
+func (r *redisClientMock) Del(ctx context.Context, keys ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		delete(r.vals, key)
+		delete(r.sets, key)
+		delete(r.setTTLs, key)
+	}
+	return nil
+}
+
+func (r *redisClientMock) Scan(ctx context.Context, match string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// path.Match shares the escaping and wildcards of the patterns used by RedisCacher
+	keys := make([]string, 0)
+	for key := range r.vals {
+		if ok, _ := path.Match(match, strings.ReplaceAll(key, "/", "")); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (r *redisClientMock) SAddWithTTL(ctx context.Context, key string, ttl time.Duration, members ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sets[key] == nil {
+		r.sets[key] = make(map[string]struct{})
+		r.setTTLs[key] = ttl
+	} else if current := r.setTTLs[key]; ttl <= 0 || (current > 0 && current < ttl) {
+		r.setTTLs[key] = ttl
+	}
+	for _, member := range members {
+		r.sets[key][member] = struct{}{}
+	}
+	return nil
+}
+
+func (r *redisClientMock) SMembers(ctx context.Context, key string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	members := make([]string, 0, len(r.sets[key]))
+	for member := range r.sets[key] {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func TestRedisCacher(t *testing.T) {
+	t.Run("delete with prefix", func(t *testing.T) {
+		client := newRedisClientMock()
+		cacher := &RedisCacher{Client: client}
+
+		_ = cacher.Set("INSTANCE_1:TABLE_users:LIST-a", []byte("a"), time.Minute)
+		_ = cacher.Set("INSTANCE_1:TABLE_users:1-b", []byte("b"), time.Minute)
+		if err := cacher.DeleteWithPrefix("INSTANCE_1:TABLE_users:LIST"); err != nil {
+			t.Fatalf("DeleteWithPrefix returned an unexpected error %v", err)
+		}
+
+		if val, _ := cacher.Get("INSTANCE_1:TABLE_users:LIST-a"); val != nil {
+			t.Error("DeleteWithPrefix expected to delete the keys under the prefix")
+		}
+		if val, _ := cacher.Get("INSTANCE_1:TABLE_users:1-b"); val == nil {
+			t.Error("DeleteWithPrefix expected to keep the other keys")
+		}
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		client := newRedisClientMock()
+		cacher := &RedisCacher{Client: client}
+
+		_ = cacher.SetWithTags("a", []byte("a"), time.Minute, []string{"tenant:1", "users"})
+		_ = cacher.SetWithTags("b", []byte("b"), time.Minute, []string{"tenant:2", "users"})
+
+		if members, _ := client.SMembers(context.Background(), "users"); !reflect.DeepEqual(members, []string{"a", "b"}) {
+			t.Errorf("SetWithTags expected to add the keys to the tag sets, got %v", members)
+		}
+
+		if err := cacher.InvalidateTags("tenant:1"); err != nil {
+			t.Fatalf("InvalidateTags returned an unexpected error %v", err)
+		}
+		if val, _ := cacher.Get("a"); val != nil {
+			t.Error("InvalidateTags expected to delete the tagged keys")
+		}
+		if val, _ := cacher.Get("b"); val == nil {
+			t.Error("InvalidateTags expected to keep the other keys")
+		}
+	})
+
+	t.Run("tag ttl", func(t *testing.T) {
+		client := newRedisClientMock()
+		cacher := &RedisCacher{Client: client}
+
+		_ = cacher.SetWithTags("a", []byte("a"), time.Minute, []string{"users"})
+		_ = cacher.SetWithTags("b", []byte("b"), time.Second, []string{"users"})
+		if ttl := client.setTTLs["users"]; ttl != time.Minute {
+			t.Errorf("expected the tag set to outlive its longest lived key, expires in %s", ttl)
+		}
+
+		_ = cacher.SetWithTags("c", []byte("c"), 0, []string{"users"})
+		_ = cacher.SetWithTags("d", []byte("d"), time.Second, []string{"users"})
+		if ttl := client.setTTLs["users"]; ttl != 0 {
+			t.Errorf("expected the tag set holding a key without expiry not to expire, expires in %s", ttl)
+		}
+	})
+}
+
+func Test_escapeGlob(t *testing.T) {
+	if actual := escapeGlob("INSTANCE_1:TABLE_a*b?[c]"); actual != `INSTANCE_1:TABLE_a\*b\?\[c\]` {
+		t.Errorf("escapeGlob returned an unexpected pattern `%s`", actual)
+	}
+}
+
+// redisBatchClientMock is a redisClientMock counting its batch calls
+type redisBatchClientMock struct {
+	*redisClientMock
+	batches int
+}
+
+func (r *redisBatchClientMock) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+	r.batches++
+	vals := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		val, _ := r.Get(ctx, key)
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
+
+func (r *redisBatchClientMock) MSetWithTTL(ctx context.Context, vals map[string][]byte, ttl time.Duration) error {
+	r.batches++
+	for key, val := range vals {
+		_ = r.Set(ctx, key, val, ttl)
+	}
+	return nil
+}
+
+func (r *redisBatchClientMock) SAddMultiWithTTL(ctx context.Context, keys []string, ttl time.Duration, member string) error {
+	r.batches++
+	for _, key := range keys {
+		_ = r.SAddWithTTL(ctx, key, ttl, member)
+	}
+	return nil
+}
+
+func TestRedisCacher_Batch(t *testing.T) {
+	batchClient := &redisBatchClientMock{redisClientMock: newRedisClientMock()}
+	for name, client := range map[string]RedisClient{
+		"single-key client": newRedisClientMock(),
+		"batch client":      batchClient,
+	} {
+		t.Run(name, func(t *testing.T) {
+			cacher := &RedisCacher{Client: client}
+			_ = cacher.SetMulti(map[string][]byte{"a": []byte("a"), "b": []byte("b")}, time.Minute)
+
+			vals, err := cacher.GetMulti("a", "missing", "b")
+			if err != nil || len(vals) != 3 || string(vals[0]) != "a" || vals[1] != nil || string(vals[2]) != "b" {
+				t.Errorf("GetMulti expected to return [a <nil> b], got %q, %v", vals, err)
+			}
+
+			_ = cacher.DeleteMulti("a", "b")
+			if vals, _ := cacher.GetMulti("a", "b"); vals[0] != nil || vals[1] != nil {
+				t.Errorf("DeleteMulti expected to delete the entries, got %q", vals)
+			}
+
+			_ = cacher.SetWithTags("c", []byte("c"), time.Minute, []string{"users", "users:1", "users:2"})
+			_ = cacher.InvalidateTags("users:2")
+			if val, _ := cacher.Get("c"); val != nil {
+				t.Errorf("SetWithTags expected to attach every tag, got `%s`", val)
+			}
+		})
+	}
+
+	if batchClient.batches != 4 {
+		t.Errorf("expected the batch client to serve the batch operations, got %d batch calls", batchClient.batches)
+	}
+}
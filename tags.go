@@ -0,0 +1,139 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TAG_PATTERN = "INSTANCE_%s:TAG_%s" //instanceId:tag
+
+	TAGS_KEY = "gorm:caches:tags"
+)
+
+var ErrTagsUnsupported = errors.New("gorm:caches: cacher does not support tags")
+
+// WithTags attaches the given tags to the entries cached by the queries run with the returned context,
+// e.g. `tenant:42`, so they can be invalidated with Caches.InvalidateTags
+func WithTags(ctx context.Context, tags ...string) context.Context {
+	if existing, ok := ctx.Value(TAGS_KEY).([]string); ok {
+		tags = append(append([]string{}, existing...), tags...)
+	}
+	return context.WithValue(ctx, TAGS_KEY, tags)
+}
+
+// TableTag is attached to every entry cached from the table
+func TableTag(tableName string) string {
+	return "TABLE_" + tableName
+}
+
+// PrimaryKeyTag is attached to every entry holding the row of the table with the given primary key
+func PrimaryKeyTag(tableName string, primaryKey string) string {
+	return fmt.Sprintf("TABLE_%s:%s", tableName, primaryKey)
+}
+
+func GenTagKey(instanceId, tag string) string {
+	return fmt.Sprintf(TAG_PATTERN, instanceId, tag)
+}
+
+// InvalidateTags evicts every entry the given tags (see WithTags, TableTag and PrimaryKeyTag) are attached to,
+// and publishes the invalidation on the InvalidationBus. With a Config.TenantResolver, only the entries of the
+// tenant of the context are evicted, those of every tenant outside of any.
+func (c *Caches) InvalidateTags(ctx context.Context, tags ...string) error {
+	tenant := c.tenant(ctx)
+	if err := c.invalidateTags(tags, tenant); err != nil {
+		return err
+	}
+
+	if c.Conf.InvalidationBus != nil {
+		return c.Conf.InvalidationBus.Publish(ctx, InvalidationEvent{
+			InstanceId: c.Conf.InstanceId,
+			Origin:     c.origin(),
+			Tenant:     tenant,
+			Tags:       tags,
+		})
+	}
+	return nil
+}
+
+// invalidateTags invalidates the tags of the tenant, if any
+func (c *Caches) invalidateTags(tags []string, tenant string) error {
+	tagged, ok := c.cacher().(TaggedCacher)
+	if !ok {
+		return ErrTagsUnsupported
+	}
+
+	tagKeys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tagKeys = append(tagKeys, c.keys().TagKey(c.Conf.InstanceId, tenantTag(tag, tenant)))
+	}
+	return tagged.InvalidateTags(tagKeys...)
+}
+
+// buildTags derives the tags of the query result from its table, the primary keys of its rows
+// and the tags on its context
+func (c *Caches) buildTags(db *gorm.DB) []string {
+	primaryKeys := getPrimaryKeysFromDest(db)
+	if len(primaryKeys) == 0 {
+		primaryKeys = getPrimaryKeysFromWhereClause(db)
+	}
+	tags := c.tagsOf(db, primaryKeys)
+
+	// preloaded graphs are also tagged with the tables of their preloads
+	if tables, ok := c.preloadGraph(db); ok {
+		tableTags := make([]string, 0, len(tables))
+		for _, tableName := range tables {
+			tableTags = append(tableTags, TableTag(tableName))
+		}
+		tags = append(tags, c.tenantTagKeys(tableTags, c.tenant(db.Statement.Context))...)
+	}
+	return tags
+}
+
+// tagsOf builds the tags of an entry of the statement's table holding the rows with the given primary keys
+func (c *Caches) tagsOf(db *gorm.DB, primaryKeys []string) []string {
+	tableName := getTableName(db)
+	tags := []string{TableTag(tableName)}
+
+	for _, primaryKey := range primaryKeys {
+		tags = append(tags, PrimaryKeyTag(tableName, primaryKey))
+	}
+	return c.tagKeys(db, tags)
+}
+
+// tagKeys builds the keys of the given tags along with the tags on the statement's context,
+// scoped to its tenant if any
+func (c *Caches) tagKeys(db *gorm.DB, tags []string) []string {
+	if ctx := db.Statement.Context; ctx != nil {
+		if ctxTags, ok := ctx.Value(TAGS_KEY).([]string); ok {
+			tags = append(tags, ctxTags...)
+		}
+	}
+
+	return c.tenantTagKeys(tags, c.tenant(db.Statement.Context))
+}
+
+// tenantTagKeys builds the keys of the given tags scoped to the tenant, along with their unscoped keys
+// so invalidations outside of any tenant evict the entries of every tenant
+func (c *Caches) tenantTagKeys(tags []string, tenant string) []string {
+	tagKeys := make([]string, 0, 2*len(tags))
+	for _, tag := range tags {
+		tagKeys = append(tagKeys, c.keys().TagKey(c.Conf.InstanceId, tenantTag(tag, tenant)))
+		if tenant != "" {
+			tagKeys = append(tagKeys, c.keys().TagKey(c.Conf.InstanceId, tag))
+		}
+	}
+	return tagKeys
+}
+
+// setWithTags stores the entry with its tags if the cacher supports them, without otherwise
+func setWithTags(cacher Cacher, key string, val []byte, ttl time.Duration, tags []string) error {
+	if tagged, ok := cacher.(TaggedCacher); ok {
+		return tagged.SetWithTags(key, val, ttl, tags)
+	}
+	return cacher.Set(key, val, ttl)
+}
@@ -0,0 +1,45 @@
+package caches
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+const TENANT_PATTERN = "%s:TENANT_%s" //tableName:tenant
+
+// GenTenantTable qualifies the table with the tenant, the keys of the tenant being built with it as table name
+// so they are grouped under the prefix of the table, see Config.TenantResolver
+func GenTenantTable(tableName, tenant string) string {
+	return fmt.Sprintf(TENANT_PATTERN, tableName, tenant)
+}
+
+// tenant resolves the tenant of the context, empty if none
+func (c *Caches) tenant(ctx context.Context) string {
+	if c.Conf.TenantResolver == nil || ctx == nil {
+		return ""
+	}
+	return c.Conf.TenantResolver(ctx)
+}
+
+// keyTable returns the table name the keys are built with, qualified with the tenant if any
+func keyTable(tableName, tenant string) string {
+	if tenant == "" {
+		return tableName
+	}
+	return GenTenantTable(tableName, tenant)
+}
+
+// statementTable returns the table name the keys of the statement are built with
+func (c *Caches) statementTable(db *gorm.DB) string {
+	return keyTable(getTableName(db), c.tenant(db.Statement.Context))
+}
+
+// tenantTag scopes the tag to the tenant if any
+func tenantTag(tag, tenant string) string {
+	if tenant == "" {
+		return tag
+	}
+	return "TENANT_" + tenant + ":" + tag
+}
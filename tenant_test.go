@@ -0,0 +1,178 @@
+package caches
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type tenantCtxKey struct{}
+
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantCtxKey{}, tenant)
+}
+
+func Test_keyTable(t *testing.T) {
+	if actual := keyTable("users", ""); actual != "users" {
+		t.Errorf("keyTable expected to return the bare table without tenant, got `%s`", actual)
+	}
+	if actual := keyTable("users", "a"); actual != "users:TENANT_a" {
+		t.Errorf("keyTable expected to qualify the table with the tenant, got `%s`", actual)
+	}
+
+	key := GenCacheKey("1", keyTable("users", "a"), LIST_KEY)
+	if actual := getTableFromKey(key); actual != "users" {
+		t.Errorf("getTableFromKey expected to return the bare table of tenant keys, got `%s`", actual)
+	}
+}
+
+func TestCaches_TenantResolver(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	cacher := NewMemoryCacher(0)
+	caches := &Caches{
+		Conf: &Config{
+			InstanceId:       "1",
+			Cacher:           cacher,
+			Serializer:       JSONSerializer{},
+			SyncInvalidation: true,
+			TenantResolver: func(ctx context.Context) string {
+				tenant, _ := ctx.Value(tenantCtxKey{}).(string)
+				return tenant
+			},
+		},
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	caches.queryCb = func(tx *gorm.DB) {
+		tx.Statement.RowsAffected = 1
+	}
+
+	ctxA := withTenant(context.Background(), "a")
+	ctxB := withTenant(context.Background(), "b")
+	cacheBoth := func() {
+		var users []mockUser
+		db.WithContext(ctxA).Find(&users)
+		db.WithContext(ctxB).Find(&users)
+		if err := caches.Flush(context.Background()); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if len(cacher.items) != 2 {
+			t.Fatalf("expected an entry per tenant, got %v", cacher.items)
+		}
+	}
+
+	cacheBoth()
+	for key := range cacher.items {
+		if !strings.HasPrefix(key, "INSTANCE_1:TABLE_mock_users:TENANT_") {
+			t.Errorf("expected the keys to carry the tenant, got `%s`", key)
+		}
+	}
+
+	// an update of tenant a leaves the entries of tenant b
+	db.WithContext(ctxA).Model(&mockUser{ID: 1}).Update("name", "john")
+	if len(cacher.items) != 1 {
+		t.Fatalf("expected only the entry of tenant b to be left, got %v", cacher.items)
+	}
+	for key := range cacher.items {
+		if !strings.Contains(key, ":TENANT_b:") {
+			t.Errorf("expected the entry of tenant b to be left, got `%s`", key)
+		}
+	}
+
+	// an update outside of any tenant evicts the entries of every tenant
+	cacheBoth()
+	db.Model(&mockUser{ID: 1}).Update("name", "john")
+	if len(cacher.items) != 0 {
+		t.Errorf("expected the entries of every tenant to be evicted, left %v", cacher.items)
+	}
+
+	// invalidating the table of a tenant leaves the entries of the others
+	cacheBoth()
+	if err := caches.InvalidateTable(ctxB, &mockUser{}); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	if len(cacher.items) != 1 {
+		t.Fatalf("expected only the entry of tenant a to be left, got %v", cacher.items)
+	}
+	for key := range cacher.items {
+		if !strings.Contains(key, ":TENANT_a:") {
+			t.Errorf("expected only the entry of tenant a to be left, got `%s`", key)
+		}
+	}
+
+	// tags behave the same: those of a tenant leave the entries of the others,
+	// those outside of any tenant evict the entries of every tenant
+	cacheBoth()
+	if err := caches.InvalidateTags(ctxA, TableTag("mock_users")); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	if len(cacher.items) != 1 {
+		t.Fatalf("expected only the entry of tenant b to be left, got %v", cacher.items)
+	}
+	cacheBoth()
+	if err := caches.InvalidateTags(context.Background(), TableTag("mock_users")); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	if len(cacher.items) != 0 {
+		t.Errorf("expected the entries of every tenant to be evicted, left %v", cacher.items)
+	}
+}
+
+func TestCaches_TenantResolver_revalidate(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	cacher := NewMemoryCacher(0)
+	caches := &Caches{
+		Conf: &Config{
+			InstanceId:   "1",
+			Cacher:       cacher,
+			Serializer:   JSONSerializer{},
+			CacheTTL:     time.Minute,
+			CacheSoftTTL: time.Millisecond,
+			TenantResolver: func(ctx context.Context) string {
+				tenant, _ := ctx.Value(tenantCtxKey{}).(string)
+				return tenant
+			},
+		},
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	var refreshes int32
+	caches.queryCb = func(tx *gorm.DB) {
+		atomic.AddInt32(&refreshes, 1)
+		*tx.Statement.Dest.(*[]mockUser) = []mockUser{{ID: 1}}
+		tx.Statement.RowsAffected = 1
+	}
+
+	ctx, cancel := context.WithCancel(WithTags(withTenant(context.Background(), "a"), "report"))
+	find := func() {
+		var users []mockUser
+		db.WithContext(ctx).Find(&users)
+		if err := caches.Flush(context.Background()); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+	}
+
+	// the stale entry is refreshed in the background, after the request is done
+	find()
+	time.Sleep(5 * time.Millisecond)
+	find()
+	cancel()
+	if act := atomic.LoadInt32(&refreshes); act != 2 || len(cacher.items) != 1 {
+		t.Fatalf("expected the stale entry to be refreshed, got %d queries and entries %v", act, cacher.items)
+	}
+
+	// the refreshed entry keeps the tenant and the tags of the request
+	if err := caches.InvalidateTags(withTenant(context.Background(), "a"), "report"); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	if len(cacher.items) != 0 {
+		t.Errorf("expected the refreshed entry to be tagged for the tenant, left %v", cacher.items)
+	}
+}
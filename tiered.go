@@ -0,0 +1,217 @@
+package caches
+
+import (
+	"strings"
+	"time"
+)
+
+// TieredCacher is a Cacher checking a local L1 (usually a MemoryCacher) before the shared L2.
+// L2 hits populate L1, and deletions are propagated to both tiers.
+type TieredCacher struct {
+	L1 Cacher
+	L2 Cacher
+
+	// L1TTL is how long entries are kept in L1
+	L1TTL time.Duration
+	// TableTTL overrides L1TTL per table, once set only the listed tables are kept in L1
+	TableTTL map[string]time.Duration
+	// KeyBuilder tells the table of the keys for TableTTL, it must match Config.KeyBuilder (DefaultKeyBuilder if nil)
+	KeyBuilder KeyBuilder
+}
+
+func (c *TieredCacher) Get(key string) ([]byte, error) {
+	l1TTL := c.l1TTL(key)
+	if l1TTL > 0 {
+		if val, err := c.L1.Get(key); err == nil && val != nil {
+			return val, nil
+		}
+	}
+
+	val, err := c.L2.Get(key)
+	if err != nil || val == nil {
+		return val, err
+	}
+
+	if l1TTL > 0 {
+		_ = c.L1.Set(key, val, l1TTL)
+	}
+	return val, nil
+}
+
+func (c *TieredCacher) Set(key string, val []byte, ttl time.Duration) error {
+	if err := c.L2.Set(key, val, ttl); err != nil {
+		return err
+	}
+
+	if l1TTL := c.l1TTL(key); l1TTL > 0 {
+		if ttl > 0 && ttl < l1TTL {
+			l1TTL = ttl
+		}
+		return c.L1.Set(key, val, l1TTL)
+	}
+	return nil
+}
+
+// SetWithTags attaches the tags in every tier supporting them
+func (c *TieredCacher) SetWithTags(key string, val []byte, ttl time.Duration, tags []string) error {
+	if err := setWithTags(c.L2, key, val, ttl, tags); err != nil {
+		return err
+	}
+
+	if l1TTL := c.l1TTL(key); l1TTL > 0 {
+		if ttl > 0 && ttl < l1TTL {
+			l1TTL = ttl
+		}
+		return setWithTags(c.L1, key, val, l1TTL, tags)
+	}
+	return nil
+}
+
+// InvalidateTags invalidates the tags in both tiers, failing with ErrTagsUnsupported if L2 does not support them
+func (c *TieredCacher) InvalidateTags(tags ...string) error {
+	l2, ok := c.L2.(TaggedCacher)
+	if !ok {
+		return ErrTagsUnsupported
+	}
+
+	var l1Err error
+	if l1, ok := c.L1.(TaggedCacher); ok {
+		l1Err = l1.InvalidateTags(tags...)
+	}
+	if err := l2.InvalidateTags(tags...); err != nil {
+		return err
+	}
+	return l1Err
+}
+
+// GetMulti reads the keys kept in L1 from it, and the others from L2 populating L1
+func (c *TieredCacher) GetMulti(keys ...string) ([][]byte, error) {
+	vals := make([][]byte, len(keys))
+
+	l1Keys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if c.l1TTL(key) > 0 {
+			l1Keys = append(l1Keys, key)
+		}
+	}
+	l1Vals := make(map[string][]byte, len(l1Keys))
+	if len(l1Keys) > 0 {
+		if res, err := getMulti(c.L1, l1Keys); err == nil {
+			for i, key := range l1Keys {
+				if res[i] != nil {
+					l1Vals[key] = res[i]
+				}
+			}
+		}
+	}
+
+	l2Keys := make([]string, 0, len(keys))
+	for i, key := range keys {
+		if val, ok := l1Vals[key]; ok {
+			vals[i] = val
+			continue
+		}
+		l2Keys = append(l2Keys, key)
+	}
+	if len(l2Keys) == 0 {
+		return vals, nil
+	}
+
+	res, err := getMulti(c.L2, l2Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	populate := make(map[time.Duration]map[string][]byte)
+	l2Vals := make(map[string][]byte, len(l2Keys))
+	for i, key := range l2Keys {
+		if res[i] == nil {
+			continue
+		}
+		l2Vals[key] = res[i]
+		if l1TTL := c.l1TTL(key); l1TTL > 0 {
+			if populate[l1TTL] == nil {
+				populate[l1TTL] = make(map[string][]byte)
+			}
+			populate[l1TTL][key] = res[i]
+		}
+	}
+	for i, key := range keys {
+		if vals[i] == nil {
+			vals[i] = l2Vals[key]
+		}
+	}
+
+	for l1TTL, l1Vals := range populate {
+		_ = setMulti(c.L1, l1Vals, l1TTL)
+	}
+	return vals, nil
+}
+
+func (c *TieredCacher) SetMulti(vals map[string][]byte, ttl time.Duration) error {
+	if err := setMulti(c.L2, vals, ttl); err != nil {
+		return err
+	}
+
+	populate := make(map[time.Duration]map[string][]byte)
+	for key, val := range vals {
+		if l1TTL := c.l1TTL(key); l1TTL > 0 {
+			if ttl > 0 && ttl < l1TTL {
+				l1TTL = ttl
+			}
+			if populate[l1TTL] == nil {
+				populate[l1TTL] = make(map[string][]byte)
+			}
+			populate[l1TTL][key] = val
+		}
+	}
+
+	var l1Err error
+	for l1TTL, l1Vals := range populate {
+		if err := setMulti(c.L1, l1Vals, l1TTL); err != nil {
+			l1Err = err
+		}
+	}
+	return l1Err
+}
+
+func (c *TieredCacher) DeleteMulti(keys ...string) error {
+	l1Err := deleteMulti(c.L1, keys)
+	if err := deleteMulti(c.L2, keys); err != nil {
+		return err
+	}
+	return l1Err
+}
+
+func (c *TieredCacher) Delete(key string) error {
+	l1Err := c.L1.Delete(key)
+	if err := c.L2.Delete(key); err != nil {
+		return err
+	}
+	return l1Err
+}
+
+func (c *TieredCacher) DeleteWithPrefix(keyPrefix string) error {
+	l1Err := c.L1.DeleteWithPrefix(keyPrefix)
+	if err := c.L2.DeleteWithPrefix(keyPrefix); err != nil {
+		return err
+	}
+	return l1Err
+}
+
+// l1TTL returns how long the key is kept in L1, zero if it is not
+func (c *TieredCacher) l1TTL(key string) time.Duration {
+	// tombstones have to be shared by all instances
+	if strings.HasPrefix(key, tombstonePrefix) {
+		return 0
+	}
+
+	if c.TableTTL == nil {
+		return c.L1TTL
+	}
+	keyBuilder := c.KeyBuilder
+	if keyBuilder == nil {
+		keyBuilder = DefaultKeyBuilder{}
+	}
+	return c.TableTTL[keyBuilder.TableName(key)]
+}
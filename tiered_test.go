@@ -0,0 +1,86 @@
+package caches
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTieredCacher(t *testing.T) {
+	t.Run("populates l1 on l2 hit", func(t *testing.T) {
+		l1, l2 := NewMemoryCacher(0), NewMemoryCacher(0)
+		cacher := &TieredCacher{L1: l1, L2: l2, L1TTL: time.Minute}
+
+		_ = l2.Set("INSTANCE_1:TABLE_users:LIST-a", []byte("a"), 0)
+		if val, _ := cacher.Get("INSTANCE_1:TABLE_users:LIST-a"); string(val) != "a" {
+			t.Fatalf("Get expected to return the L2 entry, got `%s`", val)
+		}
+		if val, _ := l1.Get("INSTANCE_1:TABLE_users:LIST-a"); string(val) != "a" {
+			t.Errorf("Get expected to populate L1 with the L2 entry, got `%s`", val)
+		}
+
+		// served by L1 while L2 lost it
+		_ = l2.Delete("INSTANCE_1:TABLE_users:LIST-a")
+		if val, _ := cacher.Get("INSTANCE_1:TABLE_users:LIST-a"); string(val) != "a" {
+			t.Errorf("Get expected to return the L1 entry, got `%s`", val)
+		}
+	})
+
+	t.Run("deletes from both tiers", func(t *testing.T) {
+		l1, l2 := NewMemoryCacher(0), NewMemoryCacher(0)
+		cacher := &TieredCacher{L1: l1, L2: l2, L1TTL: time.Minute}
+
+		_ = cacher.Set("INSTANCE_1:TABLE_users:LIST-a", []byte("a"), time.Minute)
+		_ = cacher.Set("INSTANCE_1:TABLE_users:1-b", []byte("b"), time.Minute)
+		_ = cacher.DeleteWithPrefix("INSTANCE_1:TABLE_users:LIST")
+		_ = cacher.Delete("INSTANCE_1:TABLE_users:1-b")
+
+		for _, tier := range []*MemoryCacher{l1, l2} {
+			if len(tier.items) != 0 {
+				t.Errorf("expected the entries to be deleted from both tiers, left %v", tier.items)
+			}
+		}
+	})
+
+	t.Run("per table", func(t *testing.T) {
+		l1, l2 := NewMemoryCacher(0), NewMemoryCacher(0)
+		cacher := &TieredCacher{L1: l1, L2: l2, TableTTL: map[string]time.Duration{"configs": time.Minute}}
+
+		_ = cacher.Set("INSTANCE_1:TABLE_configs:LIST-a", []byte("a"), time.Minute)
+		_ = cacher.Set("INSTANCE_1:TABLE_users:LIST-b", []byte("b"), time.Minute)
+		_ = cacher.Set(tombstonePrefix+GenCachePrefix("1", "configs"), []byte("0"), time.Minute)
+
+		if val, _ := l1.Get("INSTANCE_1:TABLE_configs:LIST-a"); val == nil {
+			t.Error("expected the entries of the configured table to be kept in L1")
+		}
+		if val, _ := l1.Get("INSTANCE_1:TABLE_users:LIST-b"); val != nil {
+			t.Error("expected the entries of other tables not to be kept in L1")
+		}
+		if val, _ := l1.Get(tombstonePrefix + GenCachePrefix("1", "configs")); val != nil {
+			t.Error("expected tombstones not to be kept in L1")
+		}
+	})
+}
+
+func TestTieredCacher_Batch(t *testing.T) {
+	l1, l2 := NewMemoryCacher(0), NewMemoryCacher(0)
+	cacher := &TieredCacher{L1: l1, L2: l2, L1TTL: time.Minute}
+
+	_ = l1.Set("INSTANCE_1:TABLE_users:1", []byte("l1"), 0)
+	_ = l2.Set("INSTANCE_1:TABLE_users:2", []byte("l2"), 0)
+
+	vals, err := cacher.GetMulti("INSTANCE_1:TABLE_users:1", "INSTANCE_1:TABLE_users:2", "INSTANCE_1:TABLE_users:3")
+	if err != nil || string(vals[0]) != "l1" || string(vals[1]) != "l2" || vals[2] != nil {
+		t.Fatalf("GetMulti expected to return [l1 l2 <nil>], got %q, %v", vals, err)
+	}
+	if val, _ := l1.Get("INSTANCE_1:TABLE_users:2"); string(val) != "l2" {
+		t.Errorf("GetMulti expected to populate L1 with the L2 entries, got `%s`", val)
+	}
+
+	_ = cacher.SetMulti(map[string][]byte{"INSTANCE_1:TABLE_users:4": []byte("d")}, time.Minute)
+	_ = cacher.DeleteMulti("INSTANCE_1:TABLE_users:1", "INSTANCE_1:TABLE_users:2")
+	for _, tier := range []*MemoryCacher{l1, l2} {
+		if len(tier.items) != 1 || string(tier.items["INSTANCE_1:TABLE_users:4"].val) != "d" {
+			t.Errorf("expected the batch operations to apply to both tiers, left %v", tier.items)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package caches
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm/schema"
+)
+
+// TypedValue holds a dynamically typed value of a query result, e.g. a column scanned into
+// map[string]interface{}, along with its type, so it is restored as such rather than as whatever
+// the Serializer decodes it into (float64 for any JSON number)
+type TypedValue struct {
+	Type  string
+	Value string
+}
+
+const (
+	typedNil   = "nil"
+	typedBytes = "bytes"
+	typedTime  = "time"
+)
+
+var (
+	typedValueType = reflect.TypeOf(TypedValue{})
+
+	// typedKinds maps the basic types a TypedValue restores, by the name of their kind
+	typedKinds = map[string]reflect.Type{}
+)
+
+func init() {
+	for _, v := range []interface{}{
+		false, "", int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0), float32(0), float64(0),
+	} {
+		typedKinds[reflect.TypeOf(v).Kind().String()] = reflect.TypeOf(v)
+	}
+}
+
+// hasDynamicValues reports whether values of the type hold interface{} values, e.g. *[]map[string]interface{}
+// as scanned by Find or *[]interface{} as plucked, which need to be cached as TypedValue
+func hasDynamicValues(t reflect.Type) bool {
+	for depth := 0; depth < maxVarDepth; depth++ {
+		switch t.Kind() {
+		case reflect.Interface:
+			return true
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			t = t.Elem()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// typedType returns the type values of the given type are cached as, holding TypedValue in place of interface{}
+func typedType(t reflect.Type) reflect.Type {
+	if !hasDynamicValues(t) {
+		return t
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return reflect.PtrTo(typedType(t.Elem()))
+	case reflect.Slice:
+		return reflect.SliceOf(typedType(t.Elem()))
+	case reflect.Array:
+		return reflect.ArrayOf(t.Len(), typedType(t.Elem()))
+	case reflect.Map:
+		return reflect.MapOf(t.Key(), typedType(t.Elem()))
+	}
+	return typedValueType
+}
+
+// typedDest converts the destination of a query into the value it is cached as, see typedType
+func typedDest(dest interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(dest)
+	if !rv.IsValid() || !hasDynamicValues(rv.Type()) {
+		return dest, nil
+	}
+
+	typed, err := toTyped(rv)
+	if err != nil {
+		return nil, err
+	}
+	return typed.Interface(), nil
+}
+
+// toTyped converts the value into its typedType
+func toTyped(rv reflect.Value) (reflect.Value, error) {
+	t := rv.Type()
+	if !hasDynamicValues(t) {
+		return rv, nil
+	}
+
+	typed := reflect.New(typedType(t)).Elem()
+	switch t.Kind() {
+	case reflect.Interface:
+		var val interface{}
+		if !rv.IsNil() {
+			val = rv.Elem().Interface()
+		}
+		typedValue, err := encodeTypedValue(val)
+		if err != nil {
+			return typed, err
+		}
+		typed.Set(reflect.ValueOf(typedValue))
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return typed, nil
+		}
+		elem, err := toTyped(rv.Elem())
+		if err != nil {
+			return typed, err
+		}
+		typed.Set(reflect.New(elem.Type()))
+		typed.Elem().Set(elem)
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice {
+			if rv.IsNil() {
+				return typed, nil
+			}
+			typed.Set(reflect.MakeSlice(typed.Type(), rv.Len(), rv.Len()))
+		}
+		for i := 0; i < rv.Len(); i++ {
+			elem, err := toTyped(rv.Index(i))
+			if err != nil {
+				return typed, err
+			}
+			typed.Index(i).Set(elem)
+		}
+	case reflect.Map:
+		if rv.IsNil() {
+			return typed, nil
+		}
+		typed.Set(reflect.MakeMapWithSize(typed.Type(), rv.Len()))
+		iter := rv.MapRange()
+		for iter.Next() {
+			elem, err := toTyped(iter.Value())
+			if err != nil {
+				return typed, err
+			}
+			typed.SetMapIndex(iter.Key(), elem)
+		}
+	}
+	return typed, nil
+}
+
+// fromTyped converts a value of the typedType of t back into a value of t
+func fromTyped(typed reflect.Value, t reflect.Type) (reflect.Value, error) {
+	if !hasDynamicValues(t) {
+		return typed, nil
+	}
+
+	rv := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Interface:
+		val, err := decodeTypedValue(typed.Interface().(TypedValue))
+		if err != nil {
+			return rv, err
+		}
+		if val != nil {
+			rv.Set(reflect.ValueOf(val))
+		}
+	case reflect.Ptr:
+		if typed.IsNil() {
+			return rv, nil
+		}
+		elem, err := fromTyped(typed.Elem(), t.Elem())
+		if err != nil {
+			return rv, err
+		}
+		rv.Set(reflect.New(t.Elem()))
+		rv.Elem().Set(elem)
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice {
+			if typed.IsNil() {
+				return rv, nil
+			}
+			rv.Set(reflect.MakeSlice(t, typed.Len(), typed.Len()))
+		}
+		for i := 0; i < typed.Len(); i++ {
+			elem, err := fromTyped(typed.Index(i), t.Elem())
+			if err != nil {
+				return rv, err
+			}
+			rv.Index(i).Set(elem)
+		}
+	case reflect.Map:
+		if typed.IsNil() {
+			return rv, nil
+		}
+		rv.Set(reflect.MakeMapWithSize(t, typed.Len()))
+		iter := typed.MapRange()
+		for iter.Next() {
+			elem, err := fromTyped(iter.Value(), t.Elem())
+			if err != nil {
+				return rv, err
+			}
+			rv.SetMapIndex(iter.Key(), elem)
+		}
+	}
+	return rv, nil
+}
+
+// encodeTypedValue encodes a value scanned from the database: nil, booleans, numbers, strings, bytes and times.
+// Named types are restored as their basic type.
+func encodeTypedValue(v interface{}) (TypedValue, error) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return TypedValue{Type: typedNil}, nil
+	}
+
+	if rv.Type().ConvertibleTo(timeType) && rv.Kind() == reflect.Struct {
+		t := rv.Convert(timeType).Interface().(time.Time)
+		return TypedValue{Type: typedTime, Value: t.Format(time.RFC3339Nano)}, nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return TypedValue{Type: rv.Kind().String(), Value: strconv.FormatBool(rv.Bool())}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return TypedValue{Type: rv.Kind().String(), Value: strconv.FormatInt(rv.Int(), 10)}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return TypedValue{Type: rv.Kind().String(), Value: strconv.FormatUint(rv.Uint(), 10)}, nil
+	case reflect.Float32, reflect.Float64:
+		return TypedValue{Type: rv.Kind().String(), Value: strconv.FormatFloat(rv.Float(), 'g', -1, rv.Type().Bits())}, nil
+	case reflect.String:
+		return TypedValue{Type: rv.Kind().String(), Value: rv.String()}, nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return TypedValue{Type: typedBytes, Value: base64.StdEncoding.EncodeToString(rv.Bytes())}, nil
+		}
+	}
+	return TypedValue{}, fmt.Errorf("%w: %T", schema.ErrUnsupportedDataType, v)
+}
+
+// decodeTypedValue restores a value encoded by encodeTypedValue
+func decodeTypedValue(typed TypedValue) (interface{}, error) {
+	switch typed.Type {
+	case typedNil:
+		return nil, nil
+	case typedBytes:
+		return base64.StdEncoding.DecodeString(typed.Value)
+	case typedTime:
+		return time.Parse(time.RFC3339Nano, typed.Value)
+	}
+
+	t, ok := typedKinds[typed.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", schema.ErrUnsupportedDataType, typed.Type)
+	}
+
+	rv := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		b, err := strconv.ParseBool(typed.Value)
+		if err != nil {
+			return nil, err
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(typed.Value, 10, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(typed.Value, 10, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		rv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(typed.Value, t.Bits())
+		if err != nil {
+			return nil, err
+		}
+		rv.SetFloat(f)
+	case reflect.String:
+		rv.SetString(typed.Value)
+	}
+	return rv.Interface(), nil
+}
@@ -0,0 +1,179 @@
+package caches
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func Test_typedDest(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 30, 0, 123, time.UTC)
+	rows := []map[string]interface{}{
+		{
+			"id":      int64(1) << 60,
+			"age":     uint8(42),
+			"score":   float32(1.5),
+			"name":    "john",
+			"active":  true,
+			"avatar":  []byte{0xff, 0x00},
+			"created": at,
+			"deleted": nil,
+		},
+	}
+
+	typed, err := typedDest(&rows)
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	data, err := JSONSerializer{}.Serialize(typed)
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	decoded := reflect.New(typedType(reflect.TypeOf(rows)))
+	if err := (JSONSerializer{}).Deserialize(data, decoded.Interface()); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	restored, err := fromTyped(decoded.Elem(), reflect.TypeOf(rows))
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	if actual := restored.Interface(); !reflect.DeepEqual(actual, rows) {
+		t.Errorf("expected the rows to keep their types, %#v got %#v", rows, actual)
+	}
+
+	if _, err := typedDest(&[]interface{}{struct{}{}}); err == nil {
+		t.Error("expected unsupported values not to be cached")
+	}
+
+	var names []string
+	if dest, _ := typedDest(&names); dest != interface{}(&names) {
+		t.Error("expected statically typed destinations to be cached as is")
+	}
+}
+
+func TestCaches_Finishers(t *testing.T) {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	caches := &Caches{
+		Conf: &Config{
+			InstanceId: "1",
+			Cacher:     NewMemoryCacher(0),
+			Serializer: JSONSerializer{},
+		},
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+
+	at := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	row := map[string]interface{}{"id": int64(7), "name": "john", "created_at": at, "deleted_at": nil}
+
+	var queries int32
+	caches.queryCb = func(tx *gorm.DB) {
+		atomic.AddInt32(&queries, 1)
+		tx.Statement.RowsAffected = 1
+		switch dest := tx.Statement.Dest.(type) {
+		case *int64:
+			*dest = 42
+		case *[]string:
+			*dest = []string{"john", "jane"}
+		case *[]interface{}:
+			*dest = []interface{}{int64(7), uint32(8), nil}
+		case *[]map[string]interface{}:
+			*dest = []map[string]interface{}{row}
+		case *map[string]interface{}:
+			for key, val := range row {
+				(*dest)[key] = val
+			}
+		case *[]mockUser:
+			*dest = []mockUser{{ID: 7, Name: "john"}}
+		case *mockUser:
+			*dest = mockUser{ID: 7, Name: "john"}
+		}
+	}
+
+	for name, tc := range map[string]struct {
+		run      func(tx *gorm.DB) interface{}
+		expected interface{}
+	}{
+		"Count": {
+			run: func(tx *gorm.DB) interface{} {
+				var count int64
+				tx.Model(&mockUser{}).Where("name LIKE ?", "j%").Count(&count)
+				return count
+			},
+			expected: int64(42),
+		},
+		"Pluck": {
+			run: func(tx *gorm.DB) interface{} {
+				var names []string
+				tx.Model(&mockUser{}).Pluck("name", &names)
+				return names
+			},
+			expected: []string{"john", "jane"},
+		},
+		"Pluck into interfaces": {
+			run: func(tx *gorm.DB) interface{} {
+				var ids []interface{}
+				tx.Model(&mockUser{}).Pluck("id", &ids)
+				return ids
+			},
+			expected: []interface{}{int64(7), uint32(8), nil},
+		},
+		"Find into maps": {
+			run: func(tx *gorm.DB) interface{} {
+				var rows []map[string]interface{}
+				tx.Model(&mockUser{}).Find(&rows)
+				return rows
+			},
+			expected: []map[string]interface{}{row},
+		},
+		"Take into map": {
+			run: func(tx *gorm.DB) interface{} {
+				result := map[string]interface{}{}
+				tx.Model(&mockUser{}).Where("name = ?", "john").Take(&result)
+				return result
+			},
+			expected: row,
+		},
+		"Find": {
+			run: func(tx *gorm.DB) interface{} {
+				var users []mockUser
+				tx.Find(&users)
+				return users
+			},
+			expected: []mockUser{{ID: 7, Name: "john"}},
+		},
+		"First": {
+			run: func(tx *gorm.DB) interface{} {
+				var user mockUser
+				tx.First(&user, 7)
+				return user
+			},
+			expected: mockUser{ID: 7, Name: "john"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			atomic.StoreInt32(&queries, 0)
+			if actual := tc.run(db); !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatalf("expected the query to return %#v, got %#v", tc.expected, actual)
+			}
+			if err := caches.Flush(context.Background()); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+
+			if actual := tc.run(db); !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected the cached result to be %#v, got %#v", tc.expected, actual)
+			}
+			if actual := atomic.LoadInt32(&queries); actual != 1 {
+				t.Errorf("expected the second query to hit the cache, ran %d queries", actual)
+			}
+		})
+	}
+}
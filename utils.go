@@ -1,5 +1,11 @@
 package caches
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
 func ContainString(target string, slice []string) bool {
 	for _, s := range slice {
 		if target == s {
@@ -8,3 +14,21 @@ func ContainString(target string, slice []string) bool {
 	}
 	return false
 }
+
+// joinErrors reports the first error, along with the number of the others
+func joinErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return fmt.Errorf("%w (and %d more errors)", errs[0], len(errs)-1)
+}
+
+// randomId returns a random hexadecimal identifier
+func randomId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,190 @@
+package caches
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	DEFAULT_WORKERS    = 10
+	DEFAULT_QUEUE_SIZE = 1000
+)
+
+// QueuePolicy decides what happens to asynchronous work submitted while the queue is full
+type QueuePolicy int
+
+const (
+	// QueuePolicyDrop discards the cache writes, they are counted in QueueStats.Dropped.
+	// Evictions are never dropped, they run on the calling goroutine instead.
+	QueuePolicyDrop QueuePolicy = iota
+	// QueuePolicyBlock waits until the queue has room
+	QueuePolicyBlock
+	// QueuePolicyInline runs the work on the calling goroutine
+	QueuePolicyInline
+)
+
+// QueueStats is a snapshot of the asynchronous work queue
+type QueueStats struct {
+	// Queued is the number of jobs waiting for a worker
+	Queued int
+	// Pending is the number of jobs queued or running
+	Pending int
+	// Dropped is the total number of jobs discarded by QueuePolicyDrop
+	Dropped uint64
+}
+
+type workerPool struct {
+	jobs   chan func()
+	quit   chan struct{}
+	policy QueuePolicy
+
+	mu      sync.Mutex
+	pending int
+	idle    chan struct{} // closed whenever pending is zero
+	closed  bool
+
+	dropped uint64
+	workers sync.WaitGroup
+}
+
+func newWorkerPool(workers, size int, policy QueuePolicy) *workerPool {
+	if workers <= 0 {
+		workers = DEFAULT_WORKERS
+	}
+	if size <= 0 {
+		size = DEFAULT_QUEUE_SIZE
+	}
+
+	p := &workerPool{
+		jobs:   make(chan func(), size),
+		quit:   make(chan struct{}),
+		policy: policy,
+		idle:   make(chan struct{}),
+	}
+	close(p.idle)
+
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *workerPool) work() {
+	defer p.workers.Done()
+	for {
+		select {
+		case job := <-p.jobs:
+			p.run(job)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *workerPool) run(job func()) {
+	defer p.done()
+	job()
+}
+
+func (p *workerPool) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending--
+	if p.pending == 0 {
+		close(p.idle)
+	}
+}
+
+// Submit schedules the job according to the queue policy, work submitted after Close runs inline.
+// It reports false if the job got dropped.
+func (p *workerPool) Submit(job func()) bool {
+	return p.submit(job, true)
+}
+
+// SubmitCritical schedules a job which must not be dropped, such as an eviction: under QueuePolicyDrop
+// it runs on the calling goroutine while the queue is full
+func (p *workerPool) SubmitCritical(job func()) {
+	p.submit(job, false)
+}
+
+func (p *workerPool) submit(job func(), droppable bool) bool {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		job()
+		return true
+	}
+	p.pending++
+	if p.pending == 1 {
+		p.idle = make(chan struct{})
+	}
+	p.mu.Unlock()
+
+	switch p.policy {
+	case QueuePolicyBlock:
+		p.jobs <- job
+	case QueuePolicyInline:
+		select {
+		case p.jobs <- job:
+		default:
+			p.run(job)
+		}
+	default:
+		select {
+		case p.jobs <- job:
+		default:
+			if !droppable {
+				p.run(job)
+				return true
+			}
+			atomic.AddUint64(&p.dropped, 1)
+			p.done()
+			return false
+		}
+	}
+	return true
+}
+
+// Flush waits until every submitted job has completed, or the context is done
+func (p *workerPool) Flush(ctx context.Context) error {
+	p.mu.Lock()
+	idle := p.idle
+	p.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close drains the pending jobs and stops the workers
+func (p *workerPool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	err := p.Flush(ctx)
+	close(p.quit)
+	p.workers.Wait()
+	return err
+}
+
+func (p *workerPool) Stats() QueueStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return QueueStats{
+		Queued:  len(p.jobs),
+		Pending: p.pending,
+		Dropped: atomic.LoadUint64(&p.dropped),
+	}
+}
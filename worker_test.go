@@ -0,0 +1,114 @@
+package caches
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_Flush(t *testing.T) {
+	var incr int32
+	pool := newWorkerPool(2, 10, QueuePolicyBlock)
+	defer pool.Close(context.Background())
+
+	for i := 0; i < 5; i++ {
+		pool.Submit(func() {
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&incr, 1)
+		})
+	}
+
+	if err := pool.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush returned an unexpected error %v", err)
+	}
+
+	if act := atomic.LoadInt32(&incr); act != 5 {
+		t.Errorf("Flush expected to wait for %d jobs, but %d completed", 5, act)
+	}
+}
+
+func TestWorkerPool_Flush_timeout(t *testing.T) {
+	pool := newWorkerPool(1, 1, QueuePolicyBlock)
+	defer pool.Close(context.Background())
+
+	pool.Submit(func() {
+		time.Sleep(200 * time.Millisecond)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.Flush(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Flush expected to return %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestWorkerPool_Submit(t *testing.T) {
+	t.Run("drop", func(t *testing.T) {
+		release := make(chan struct{})
+		pool := newWorkerPool(1, 1, QueuePolicyDrop)
+		defer pool.Close(context.Background())
+
+		pool.Submit(func() { <-release }) // occupies the worker
+		time.Sleep(10 * time.Millisecond)
+		pool.Submit(func() {}) // fills the queue
+		if pool.Submit(func() {}) {
+			t.Error("Submit expected to report the job as dropped")
+		}
+
+		stats := pool.Stats()
+		if stats.Dropped != 1 || stats.Queued != 1 || stats.Pending != 2 {
+			t.Errorf("unexpected queue stats %+v", stats)
+		}
+		close(release)
+	})
+
+	t.Run("critical", func(t *testing.T) {
+		var ran int32
+		release := make(chan struct{})
+		pool := newWorkerPool(1, 1, QueuePolicyDrop)
+		defer pool.Close(context.Background())
+
+		pool.Submit(func() { <-release })
+		time.Sleep(10 * time.Millisecond)
+		pool.Submit(func() {})
+		pool.SubmitCritical(func() { atomic.AddInt32(&ran, 1) })
+
+		if atomic.LoadInt32(&ran) != 1 {
+			t.Error("SubmitCritical expected to run the job inline rather than dropping it")
+		}
+		if stats := pool.Stats(); stats.Dropped != 0 {
+			t.Errorf("SubmitCritical expected not to drop the job, got %d dropped", stats.Dropped)
+		}
+		close(release)
+	})
+
+	t.Run("inline", func(t *testing.T) {
+		var ran int32
+		release := make(chan struct{})
+		pool := newWorkerPool(1, 1, QueuePolicyInline)
+		defer pool.Close(context.Background())
+
+		pool.Submit(func() { <-release })
+		time.Sleep(10 * time.Millisecond)
+		pool.Submit(func() {})
+		pool.Submit(func() { atomic.AddInt32(&ran, 1) })
+
+		if atomic.LoadInt32(&ran) != 1 {
+			t.Error("Submit expected to run the job inline while the queue is full")
+		}
+		close(release)
+	})
+
+	t.Run("after close", func(t *testing.T) {
+		var ran int32
+		pool := newWorkerPool(1, 1, QueuePolicyDrop)
+		_ = pool.Close(context.Background())
+
+		pool.Submit(func() { atomic.AddInt32(&ran, 1) })
+
+		if atomic.LoadInt32(&ran) != 1 {
+			t.Error("Submit expected to run the job inline once the pool is closed")
+		}
+	})
+}